@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/plesk/docker-fs/lib/manager"
+)
+
+// docker-fs export-changes [-format tar|patch] [-o file] <container> [path...]
+func exportChangesCommand(args []string) int {
+	flags := newFlagSet("export-changes", "[options] <container> [path...]", "Pack files changed in container into a bundle.")
+	var opts manager.ExportOptions
+	var output string
+	flags.StringVar(&opts.Format, "format", "tar", "Bundle format: tar or patch")
+	flags.StringVar(&output, "o", "-", "Output file")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	opts.Paths = flags.Args()[1:]
+
+	mng := newManager()
+	export := func(w io.Writer) error {
+		return mng.ExportChanges(flags.Arg(0), opts, w)
+	}
+	var err error
+	if output == "-" {
+		err = export(os.Stdout)
+	} else {
+		err = writeFileAtomically(output, export)
+	}
+	if err != nil {
+		return printError(err)
+	}
+	return exitOK
+}
+
+// Writes the file with content written by fn, the file is replaced only if fn succeeds,
+// so a failed export doesn't leave a truncated bundle behind.
+func writeFileAtomically(path string, fn func(w io.Writer) error) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	// it's gone after rename
+	defer os.Remove(file.Name())
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return err
+	}
+	if err := fn(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// docker-fs import-changes <container> <bundle|->
+func importChangesCommand(args []string) int {
+	flags := newFlagSet("import-changes", "<container> <bundle|->", "Replay tar bundle created by export-changes onto container.")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	var r io.Reader = os.Stdin
+	if flags.Arg(1) != "-" {
+		file, err := os.Open(flags.Arg(1))
+		if err != nil {
+			return printError(err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	mng := newManager()
+	if err := mng.ImportChanges(flags.Arg(0), r); err != nil {
+		return printError(err)
+	}
+	return exitOK
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/plesk/docker-fs/lib/dockerfs"
+	"github.com/plesk/docker-fs/lib/manager"
+)
+
+// Exit codes of subcommands
+const (
+	exitOK = 0
+	// operation failed
+	exitFailure = 1
+	// incorrect arguments
+	exitUsage = 2
+	// container or mount is not found, e.g. `status` of not mounted container
+	exitNotFound = 3
+)
+
+// How long `mount` waits for daemonized process to mount FS
+const mountTimeout = 60 * time.Second
+
+// Prints value as indented JSON to stdout.
+func printJSON(v interface{}) int {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// Prints error and returns exit code matching it.
+func printError(err error) int {
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+	if errors.As(err, &manager.ErrorNotMounted{}) || errors.As(err, &manager.ErrorWatcherNotRunning{}) ||
+		errors.As(err, &dockerfs.ErrorNotFound{}) {
+		return exitNotFound
+	}
+	return exitFailure
+}
+
+func newFlagSet(name, usage, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s %s\n\n", os.Args[0], name, usage)
+		fmt.Fprintf(flags.Output(), "%s\n\n", description)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// docker-fs ls [-json]
+func lsCommand(args []string) int {
+	flags := newFlagSet("ls", "[options]", "List containers along with their mount points.")
+	jsonOutput := flags.Bool("json", false, "Print JSON")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	cts, err := newManager().ListContainers()
+	if err != nil {
+		return printError(err)
+	}
+	if *jsonOutput {
+		if cts == nil {
+			cts = []manager.Container{}
+		}
+		return printJSON(cts)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tNAME\tSTATE\tMOUNT POINT\n")
+	for _, ct := range cts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", ct.ShortId, ct.Name, ct.State, ct.MountPoint)
+	}
+	w.Flush()
+	return exitOK
+}
+
+// Creates manager with config of the user, exits if the config is invalid.
+func newManager() *manager.Manager {
+	return manager.New(loadConfig())
+}
+
+// Reads ~/.config/docker-fs/config.yaml, exits if it's invalid.
+func loadConfig() *manager.Config {
+	path, err := manager.ConfigPath()
+	if err != nil {
+		// there is no config without home directory
+		return &manager.Config{}
+	}
+	config, err := manager.LoadConfig(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFailure)
+	}
+	return config
+}
+
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+// Numeric flag which is nil unless it's set, e.g. -uid 1000
+type idFlag struct {
+	value **uint32
+}
+
+func (f idFlag) String() string {
+	if f.value == nil || *f.value == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(**f.value), 10)
+}
+
+func (f idFlag) Set(value string) error {
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return err
+	}
+	result := uint32(id)
+	*f.value = &result
+	return nil
+}
+
+// Boolean flag which is nil unless it's set, so it overrides config only if it's given
+type boolFlag struct {
+	value **bool
+}
+
+func (f boolFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f boolFlag) String() string {
+	if f.value == nil || *f.value == nil {
+		return ""
+	}
+	return strconv.FormatBool(**f.value)
+}
+
+func (f boolFlag) Set(value string) error {
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*f.value = &result
+	return nil
+}
+
+// String flag which is nil unless it's set
+type stringFlag struct {
+	value **string
+}
+
+func (f stringFlag) String() string {
+	if f.value == nil || *f.value == nil {
+		return ""
+	}
+	return **f.value
+}
+
+func (f stringFlag) Set(value string) error {
+	*f.value = &value
+	return nil
+}
+
+// Adds flags of container mount options, they are converted back by mountArgs.
+// Options which are not given stay nil, so they don't override config.
+func addMountFlags(flags *flag.FlagSet, opts *manager.MountOptions) {
+	flags.Var(boolFlag{&opts.RewriteSymlinks}, "rewrite-symlinks", "Make absolute symlinks point to files inside mount point")
+	flags.Var(boolFlag{&opts.AllowBindWrites}, "allow-bind-writes", "Allow writes to host paths bind-mounted into container")
+	flags.Var(boolFlag{&opts.DiffView}, "diff-view", "Show only changed files in .dockerfs/diff")
+	flags.Var(boolFlag{&opts.ReadOnly}, "read-only", "Reject all modifications")
+	flags.Var(boolFlag{&opts.Reconnect}, "reconnect", "Follow container across restarts and recreation (by name or compose service)")
+	flags.Var(stringFlag{&opts.Cache}, "cache", "Kernel cache `mode`: none or metadata (default none)")
+	flags.Var(idFlag{&opts.Uid}, "uid", "Owner `uid` of files (default current user)")
+	flags.Var(idFlag{&opts.Gid}, "gid", "Group `gid` of files (default group of current user)")
+	flags.Var(stringFlag{&opts.LogLevel}, "log-level", "Logging `level` of the mount process, its log is shown by logs command (default warning)")
+}
+
+// Returns `mount` flags of the options which are set.
+func mountArgs(opts manager.MountOptions) []string {
+	flags := flag.NewFlagSet("mount", flag.ContinueOnError)
+	addMountFlags(flags, &opts)
+	var args []string
+	flags.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if value == "" {
+			return
+		}
+		if _, ok := f.Value.(boolFlag); ok && value == "true" {
+			args = append(args, "-"+f.Name)
+		} else {
+			args = append(args, "-"+f.Name+"="+value)
+		}
+	})
+	return args
+}
+
+// docker-fs mount [options] <container> <mount-point>
+func mountCommand(args []string) int {
+	flags := newFlagSet("mount", "[options] <container|image> <mount-point>",
+		"Mount container (or image) FS, the command returns once FS is mounted.\n"+
+			"Options are taken from ~/.config/docker-fs/config.yaml, flags override them.")
+	var flagOpts manager.MountOptions
+	var image, foreground, jsonOutput bool
+	var engine string
+	flags.BoolVar(&image, "image", false, "Mount image read-only instead of container")
+	flags.BoolVar(&flagOpts.Layers, "layers", false, "Show every image layer in its own directory (with -image)")
+	addMountFlags(flags, &flagOpts)
+	flags.StringVar(&engine, "engine", "", "Docker engine endpoint, e.g. unix:/var/run/docker.sock")
+	flags.BoolVar(&foreground, "foreground", false, "Don't daemonize, serve FS until it's unmounted")
+	flags.BoolVar(&jsonOutput, "json", false, "Print JSON")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitUsage
+	}
+	target, mountPoint := flags.Arg(0), flags.Arg(1)
+
+	config := loadConfig()
+	if engine != "" {
+		config.Engine = engine
+	}
+	// options set in config are overridden by flags in the manager
+	flagOpts.Daemonize = !foreground
+	mng := manager.New(config)
+	var err error
+	if image {
+		err = mng.MountImage(target, mountPoint, flagOpts)
+	} else {
+		err = mng.MountContainer(target, mountPoint, flagOpts)
+	}
+	if err != nil {
+		return printError(err)
+	}
+	if foreground || manager.IsDaemon() {
+		// FS is unmounted already
+		return exitOK
+	}
+
+	if err := manager.WaitMounted(mountPoint, mountTimeout); err != nil {
+		fmt.Fprintf(os.Stderr, "See `%s logs %s` for details.\n", os.Args[0], mountPoint)
+		return printError(err)
+	}
+	if jsonOutput {
+		return printJSON(map[string]string{"target": target, "mount_point": mountPoint})
+	}
+	return exitOK
+}
+
+// docker-fs umount [-json] <container|mount-point>
+func umountCommand(args []string) int {
+	flags := newFlagSet("umount", "[options] <container|mount-point>", "Unmount container FS.")
+	jsonOutput := flags.Bool("json", false, "Print JSON")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	mng := newManager()
+	mnt, err := mng.FindMount(flags.Arg(0))
+	if err != nil {
+		// not recorded, but it still can be a mount point (e.g. a disconnected one)
+		mnt = &manager.Mount{MountPoint: flags.Arg(0)}
+	}
+	if err := mng.Unmount(mnt.MountPoint); err != nil {
+		return printError(err)
+	}
+	if *jsonOutput {
+		return printJSON(mnt)
+	}
+	return exitOK
+}
+
+// docker-fs status [-json] [<container|mount-point>]
+func statusCommand(args []string) int {
+	flags := newFlagSet("status", "[options] [<container|mount-point>]",
+		"Show mounts, or the mount of the container (exit code is 3 if it's not mounted).")
+	jsonOutput := flags.Bool("json", false, "Print JSON")
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	mng := newManager()
+	var mounts []manager.Mount
+	if flags.NArg() == 1 {
+		mnt, err := mng.FindMount(flags.Arg(0))
+		if err != nil {
+			return printError(err)
+		}
+		if !mnt.Mounted() {
+			return printError(manager.ErrorNotMounted{Target: flags.Arg(0)})
+		}
+		mounts = append(mounts, *mnt)
+	} else {
+		var err error
+		if mounts, err = mng.Mounts(); err != nil {
+			return printError(err)
+		}
+	}
+
+	if *jsonOutput {
+		if flags.NArg() == 1 {
+			return printJSON(mounts[0])
+		}
+		if mounts == nil {
+			mounts = []manager.Mount{}
+		}
+		return printJSON(mounts)
+	}
+	printMounts(mounts)
+	return exitOK
+}
+
+// docker-fs gc [-json]
+func gcCommand(args []string) int {
+	flags := newFlagSet("gc", "[options]", "Clean up mounts of crashed processes: disconnected mount points are lazily unmounted.")
+	jsonOutput := flags.Bool("json", false, "Print JSON")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	removed, err := newManager().GC()
+	if err != nil {
+		return printError(err)
+	}
+	if *jsonOutput {
+		if removed == nil {
+			removed = []manager.Mount{}
+		}
+		return printJSON(removed)
+	}
+	printMounts(removed)
+	return exitOK
+}
+
+func printMounts(mounts []manager.Mount) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "TARGET\tMOUNT POINT\tSTATE\tPID\n")
+	for _, mnt := range mounts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", mnt.Target, mnt.MountPoint, mnt.State, mnt.Pid)
+	}
+	w.Flush()
+}
+
+// docker-fs stats [-json] <container|mount-point>
+func statsCommand(args []string) int {
+	flags := newFlagSet("stats", "[options] <container|mount-point>", "Show resource usage of the mount process.")
+	jsonOutput := flags.Bool("json", false, "Print JSON")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	mng := newManager()
+	mnt, err := mng.FindMount(flags.Arg(0))
+	if err != nil {
+		return printError(err)
+	}
+	stats, err := mng.MountStats(mnt)
+	if err != nil {
+		return printError(err)
+	}
+	if *jsonOutput {
+		return printJSON(stats)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Mount point:\t%s\n", mnt.MountPoint)
+	fmt.Fprintf(w, "Target:\t%s\n", mnt.Target)
+	fmt.Fprintf(w, "Pid:\t%d\n", stats.Pid)
+	fmt.Fprintf(w, "Uptime:\t%s\n", stats.Uptime)
+	fmt.Fprintf(w, "Goroutines:\t%d\n", stats.Goroutines)
+	fmt.Fprintf(w, "Heap:\t%d KiB\n", stats.HeapAlloc/1024)
+	fmt.Fprintf(w, "Memory from OS:\t%d KiB\n", stats.Sys/1024)
+	fmt.Fprintf(w, "Log level:\t%s\n", stats.LogLevel)
+	w.Flush()
+	return exitOK
+}
+
+// docker-fs flush <container|mount-point>
+// docker-fs refresh <container|mount-point>
+func mountCallCommand(name string, args []string) int {
+	descriptions := map[string]string{
+		"flush":   "Save files which are still open for writing to container.",
+		"refresh": "Fetch content of the mount again and drop caches, e.g. after container is rebuilt.",
+	}
+	flags := newFlagSet(name, "<container|mount-point>", descriptions[name])
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	mng := newManager()
+	mnt, err := mng.FindMount(flags.Arg(0))
+	if err != nil {
+		return printError(err)
+	}
+	if name == "flush" {
+		err = mng.Flush(mnt)
+	} else {
+		err = mng.Refresh(mnt)
+	}
+	if err != nil {
+		return printError(err)
+	}
+	return exitOK
+}
+
+// docker-fs log-level <container|mount-point> <level>
+func logLevelCommand(args []string) int {
+	flags := newFlagSet("log-level", "<container|mount-point> <level>",
+		"Change logging level of the mount process: critical, error, warning, info, debug or trace.")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	mng := newManager()
+	mnt, err := mng.FindMount(flags.Arg(0))
+	if err != nil {
+		return printError(err)
+	}
+	if err := mng.SetLogLevel(mnt, flags.Arg(1)); err != nil {
+		return printError(err)
+	}
+	return exitOK
+}
+
+// How often `logs -f` checks log file for new lines
+const followInterval = 500 * time.Millisecond
+
+// docker-fs logs [-f] [-n lines] <container|mount-point>
+func logsCommand(args []string) int {
+	flags := newFlagSet("logs", "[options] <container|mount-point>",
+		"Print log of the background mount process, it's kept after unmount (or failed mount) as well.")
+	follow := flags.Bool("f", false, "Follow log output")
+	lines := flags.Int("n", 10, "Number of last lines to print, 0 prints the whole log")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	path, err := newManager().LogFile(flags.Arg(0))
+	if err != nil {
+		return printError(err)
+	}
+	return printLog(path, *lines, *follow)
+}
+
+// Prints the last lines of the log file, then its new lines with follow.
+func printLog(path string, lines int, follow bool) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return printError(err)
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return printError(err)
+	}
+	os.Stdout.Write(lastLines(content, lines))
+	for follow {
+		time.Sleep(followInterval)
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			return printError(err)
+		}
+	}
+	return exitOK
+}
+
+// Returns n last lines of the text, whole text if n is 0.
+func lastLines(text []byte, n int) []byte {
+	if n <= 0 {
+		return text
+	}
+	end := bytes.TrimSuffix(text, []byte("\n"))
+	for i := 0; i < n; i++ {
+		pos := bytes.LastIndexByte(end, '\n')
+		if pos < 0 {
+			return text
+		}
+		end = end[:pos]
+	}
+	return text[len(end)+1:]
+}
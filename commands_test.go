@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/plesk/docker-fs/lib/dockerfs"
+	"github.com/plesk/docker-fs/lib/manager"
+)
+
+func parseMountFlags(t *testing.T, args []string) manager.MountOptions {
+	var opts manager.MountOptions
+	flags := flag.NewFlagSet("mount", flag.ContinueOnError)
+	addMountFlags(flags, &opts)
+	if err := flags.Parse(args); err != nil {
+		t.Fatalf("Cannot parse %v: %v", args, err)
+	}
+	return opts
+}
+
+func TestMountFlagsOverrideConfig(t *testing.T) {
+	readOnly, uid, cache := true, uint32(1000), "metadata"
+	config := &manager.Config{MountOptions: manager.MountOptions{ReadOnly: &readOnly, Uid: &uid, Cache: &cache}}
+
+	opts := config.Options()
+	opts.Override(parseMountFlags(t, []string{"-read-only=false", "-rewrite-symlinks", "-uid", "33"}))
+	if opts.ReadOnly == nil || *opts.ReadOnly {
+		t.Errorf("read_only of config is not overridden by flag")
+	}
+	if opts.RewriteSymlinks == nil || !*opts.RewriteSymlinks {
+		t.Errorf("rewrite_symlinks is not set by flag")
+	}
+	if opts.Uid == nil || *opts.Uid != 33 {
+		t.Errorf("uid of config is not overridden by flag")
+	}
+	// options without flags are kept
+	if opts.Cache == nil || *opts.Cache != "metadata" {
+		t.Errorf("cache of config is overridden without flag")
+	}
+	if opts.DiffView != nil || opts.Gid != nil || opts.LogLevel != nil {
+		t.Errorf("Options are set without flags: %+v", opts)
+	}
+}
+
+func TestMountArgs(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		expected []string
+	}{
+		{nil, nil},
+		{[]string{"-read-only"}, []string{"-read-only"}},
+		{[]string{"-read-only=false"}, []string{"-read-only=false"}},
+		{
+			[]string{"-reconnect", "-cache", "metadata", "-uid", "0", "-log-level", "debug", "-diff-view"},
+			[]string{"-cache=metadata", "-diff-view", "-log-level=debug", "-reconnect", "-uid=0"},
+		},
+	} {
+		opts := parseMountFlags(t, test.args)
+		args := mountArgs(opts)
+		if !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("Incorrect args of %v: expected %v, actual %v", test.args, test.expected, args)
+		}
+		// args are parsed back to the same options
+		if parsed := parseMountFlags(t, args); !reflect.DeepEqual(parsed, opts) {
+			t.Errorf("Options of %v are not restored by %v: expected %+v, actual %+v", test.args, args, opts, parsed)
+		}
+	}
+}
+
+func TestPrintError(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		code int
+	}{
+		{errors.New("failed"), exitFailure},
+		{manager.ErrorNotMounted{Target: "web"}, exitNotFound},
+		{manager.ErrorWatcherNotRunning{}, exitNotFound},
+		// container is not found by docker
+		{fmt.Errorf("Cannot inspect container web: %w", dockerfs.ErrorNotFound{}), exitNotFound},
+	} {
+		if code := printError(test.err); code != test.code {
+			t.Errorf("Incorrect exit code of %q: expected %d, actual %d", test.err, test.code, code)
+		}
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		n        int
+		expected string
+	}{
+		{"a\nb\nc\n", 0, "a\nb\nc\n"},
+		{"a\nb\nc\n", 1, "c\n"},
+		{"a\nb\nc\n", 2, "b\nc\n"},
+		{"a\nb\nc\n", 3, "a\nb\nc\n"},
+		{"a\nb\nc\n", 10, "a\nb\nc\n"},
+		// the last line is not terminated
+		{"a\nb\nc", 2, "b\nc"},
+		{"", 1, ""},
+		{"\n\n", 1, "\n"},
+	} {
+		if actual := string(lastLines([]byte(test.text), test.n)); actual != test.expected {
+			t.Errorf("lastLines(%q, %d): expected %q, actual %q", test.text, test.n, test.expected, actual)
+		}
+	}
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker_fs_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "changes.tar")
+
+	// failed export leaves nothing behind
+	err = writeFileAtomically(path, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errors.New("failed")
+	})
+	if err == nil {
+		t.Errorf("Error of export is not returned")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Files are left after failed export: %v", files)
+	}
+
+	err = writeFileAtomically(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "bundle")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("writeFileAtomically() failed: %v", err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "bundle" {
+		t.Errorf("Incorrect file written: %q, %v", data, err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Temporary files are left: %v", files)
+	}
+}
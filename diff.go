@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+
+	"github.com/plesk/docker-fs/lib/manager"
+)
+
+// docker-fs diff [-stat] [-removed] [-json] <container> [path...]
+func diffCommand(args []string) int {
+	flags := newFlagSet("diff", "[options] <container> [path...]", "Show changes of files in container against its image.")
+	var opts manager.DiffOptions
+	flags.BoolVar(&opts.Stat, "stat", false, "Show only statistics of changed lines")
+	flags.BoolVar(&opts.Removed, "removed", false, "Show removed files as well")
+	jsonOutput := flags.Bool("json", false, "Print changed files with statistics (and diffs unless -stat is set) as JSON")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	opts.Paths = flags.Args()[1:]
+
+	mng := newManager()
+	if *jsonOutput {
+		files, err := mng.DiffFiles(flags.Arg(0), opts)
+		if err != nil {
+			return printError(err)
+		}
+		if files == nil {
+			files = []manager.DiffFile{}
+		}
+		return printJSON(files)
+	}
+	if err := mng.Diff(flags.Arg(0), opts, os.Stdout); err != nil {
+		return printError(err)
+	}
+	return exitOK
+}
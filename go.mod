@@ -8,4 +8,5 @@ require (
 	github.com/manifoldco/promptui v0.8.0
 	github.com/sevlyar/go-daemon v0.1.5
 	golang.org/x/sys v0.0.0-20200219091948-cb0a6d8edb6c // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
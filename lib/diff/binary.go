@@ -0,0 +1,109 @@
+package diff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Name of a missing file in diffs
+const devNull = "/dev/null"
+
+// Git patches of binary files are base85 encoded, lines encode up to 52 bytes.
+const (
+	base85Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+	binaryLineSize = 52
+)
+
+// GitBinary writes diff of a and b as git binary patch (like `git diff --binary`), unlike
+// "Binary files differ" of Unified it can be applied by `git apply`. Names are prefixed
+// with a/ and b/, /dev/null stands for a missing file. Nothing is written if contents are equal.
+func GitBinary(w io.Writer, oldName, newName string, a, b []byte) error {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	header := &strings.Builder{}
+	switch {
+	case oldName == devNull:
+		fmt.Fprintf(header, "diff --git a%s %s\nnew file mode 100644\n", strings.TrimPrefix(newName, "b"), newName)
+	case newName == devNull:
+		fmt.Fprintf(header, "diff --git %s b%s\ndeleted file mode 100644\n", oldName, strings.TrimPrefix(oldName, "a"))
+	default:
+		fmt.Fprintf(header, "diff --git %s %s\n", oldName, newName)
+	}
+	// full index is required by git to apply binary patches
+	fmt.Fprintf(header, "index %s..%s\nGIT binary patch\n", blobHash(a, oldName == devNull), blobHash(b, newName == devNull))
+	if _, err := io.WriteString(w, header.String()); err != nil {
+		return err
+	}
+	// forward hunk and reverse one
+	if err := writeLiteral(w, b); err != nil {
+		return err
+	}
+	return writeLiteral(w, a)
+}
+
+// Returns git object ID of the file content, zero one if the file is missing.
+func blobHash(data []byte, missing bool) string {
+	if missing {
+		return strings.Repeat("0", 2*sha1.Size)
+	}
+	hash := sha1.New()
+	fmt.Fprintf(hash, "blob %d\x00", len(data))
+	hash.Write(data)
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// Writes the whole content as a literal hunk of git binary patch.
+func writeLiteral(w io.Writer, data []byte) error {
+	var deflated bytes.Buffer
+	zw := zlib.NewWriter(&deflated)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	out := &strings.Builder{}
+	fmt.Fprintf(out, "literal %d\n", len(data))
+	for chunk := deflated.Bytes(); len(chunk) > 0; {
+		n := len(chunk)
+		if n > binaryLineSize {
+			n = binaryLineSize
+		}
+		// line length: A-Z for 1-26 bytes, a-z for 27-52 bytes
+		if n <= 26 {
+			out.WriteByte(byte('A' + n - 1))
+		} else {
+			out.WriteByte(byte('a' + n - 27))
+		}
+		encodeBase85(out, chunk[:n])
+		out.WriteByte('\n')
+		chunk = chunk[n:]
+	}
+	out.WriteByte('\n')
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// Encodes every 4 bytes (zero padded) as 5 characters, most significant first.
+func encodeBase85(out *strings.Builder, data []byte) {
+	for i := 0; i < len(data); i += 4 {
+		var value uint32
+		for j := 0; j < 4; j++ {
+			value <<= 8
+			if i+j < len(data) {
+				value |= uint32(data[i+j])
+			}
+		}
+		var chars [5]byte
+		for j := 4; j >= 0; j-- {
+			chars[j] = base85Alphabet[value%85]
+			value /= 85
+		}
+		out.Write(chars[:])
+	}
+}
@@ -0,0 +1,261 @@
+// Package diff produces line based diffs of file contents in unified format.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Number of unchanged lines shown around changes
+const contextLines = 3
+
+// Files with more changed lines are reported as complete replacement
+const maxEditDistance = 1000
+
+type OpKind int
+
+const (
+	Equal OpKind = iota
+	Insert
+	Delete
+)
+
+// Op is a single line of the edit script.
+type Op struct {
+	Kind OpKind
+	Line string
+}
+
+// Lines returns the shortest edit script transforming a into b (Myers algorithm).
+func Lines(a, b []string) []Op {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	// v[k+offset] is the furthest x on diagonal k.
+	// trace[d] keeps diagonals -d-1..d+1 of v as they were before step d.
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	for d := 0; d <= max && d <= maxEditDistance; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+				x = v[k+1+offset]
+			} else {
+				x = v[k-1+offset] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+offset] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, d)
+			}
+		}
+	}
+	return replace(a, b)
+}
+
+func backtrack(trace [][]int, a, b []string, d int) []Op {
+	var ops []Op
+	x, y := len(a), len(b)
+	for ; d > 0; d-- {
+		v, offset := trace[d], d+1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[prevK+offset]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, Op{Equal, a[x]})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, Op{Insert, b[y]})
+		} else {
+			x--
+			ops = append(ops, Op{Delete, a[x]})
+		}
+	}
+	for x > 0 {
+		x--
+		ops = append(ops, Op{Equal, a[x]})
+	}
+	// reverse
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+func replace(a, b []string) []Op {
+	ops := make([]Op, 0, len(a)+len(b))
+	for _, line := range a {
+		ops = append(ops, Op{Delete, line})
+	}
+	for _, line := range b {
+		ops = append(ops, Op{Insert, line})
+	}
+	return ops
+}
+
+// SplitLines splits content into lines keeping line endings.
+func SplitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// IsBinary reports whether content doesn't look like text.
+func IsBinary(data []byte) bool {
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// Stat returns number of inserted and deleted lines.
+func Stat(ops []Op) (inserted, deleted int) {
+	for _, op := range ops {
+		switch op.Kind {
+		case Insert:
+			inserted++
+		case Delete:
+			deleted++
+		}
+	}
+	return
+}
+
+// Unified writes diff of a and b in unified format.
+// Nothing is written if contents are equal.
+func Unified(w io.Writer, oldName, newName string, a, b []byte) error {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	if IsBinary(a) || IsBinary(b) {
+		_, err := fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName)
+		return err
+	}
+	ops := Lines(SplitLines(a), SplitLines(b))
+	if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName); err != nil {
+		return err
+	}
+	for _, h := range hunks(ops) {
+		if err := h.write(w, ops); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type hunk struct {
+	// range of ops
+	start, end int
+	// first lines (0-based) in a and b
+	oldStart, newStart int
+}
+
+func hunks(ops []Op) []hunk {
+	var result []hunk
+	oldLine, newLine := 0, 0
+	var cur *hunk
+	lastChange := -1
+	for i, op := range ops {
+		if op.Kind != Equal {
+			// changes separated by up to 2*contextLines unchanged lines are shown in one hunk
+			if cur == nil || i-lastChange > 2*contextLines+1 {
+				if cur != nil {
+					cur.end = lastChange + contextLines + 1
+					result = append(result, *cur)
+				}
+				start := i - contextLines
+				if start < 0 {
+					start = 0
+				}
+				// lines before the change
+				back := i - start
+				cur = &hunk{start: start, oldStart: oldLine - back, newStart: newLine - back}
+			}
+			lastChange = i
+		}
+		switch op.Kind {
+		case Equal:
+			oldLine++
+			newLine++
+		case Delete:
+			oldLine++
+		case Insert:
+			newLine++
+		}
+	}
+	if cur != nil {
+		cur.end = lastChange + contextLines + 1
+		result = append(result, *cur)
+	}
+	for i := range result {
+		if result[i].end > len(ops) {
+			result[i].end = len(ops)
+		}
+	}
+	return result
+}
+
+func (h hunk) write(w io.Writer, ops []Op) error {
+	oldCount, newCount := 0, 0
+	for _, op := range ops[h.start:h.end] {
+		if op.Kind != Insert {
+			oldCount++
+		}
+		if op.Kind != Delete {
+			newCount++
+		}
+	}
+	if _, err := fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(h.oldStart, oldCount), hunkRange(h.newStart, newCount)); err != nil {
+		return err
+	}
+	for _, op := range ops[h.start:h.end] {
+		prefix := " "
+		switch op.Kind {
+		case Insert:
+			prefix = "+"
+		case Delete:
+			prefix = "-"
+		}
+		line := op.Line
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n\\ No newline at end of file\n"
+		}
+		if _, err := io.WriteString(w, prefix+line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Formats hunk range the way diff does: 1-based start, count is omitted if it's 1.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,101 @@
+package diff
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func ExampleUnified() {
+	a := []byte("one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n")
+	b := []byte("one\n2\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven\n")
+	Unified(os.Stdout, "a/numbers.txt", "b/numbers.txt", a, b)
+	// Output:
+	// --- a/numbers.txt
+	// +++ b/numbers.txt
+	// @@ -1,5 +1,5 @@
+	//  one
+	// -two
+	// +2
+	//  three
+	//  four
+	//  five
+	// @@ -8,3 +8,4 @@
+	//  eight
+	//  nine
+	//  ten
+	// +eleven
+}
+
+func TestLines(t *testing.T) {
+	testdata := []struct {
+		a, b string
+	}{
+		{"", ""},
+		{"", "a\nb\n"},
+		{"a\nb\n", ""},
+		{"a\nb\nc\n", "a\nc\n"},
+		{"a\nb\nc\nd\n", "x\nb\ny\nd\nz\n"},
+		{"a\na\na\n", "a\nb\na\n"},
+	}
+	for _, test := range testdata {
+		a, b := SplitLines([]byte(test.a)), SplitLines([]byte(test.b))
+		var oldLines, newLines []string
+		for _, op := range Lines(a, b) {
+			if op.Kind != Insert {
+				oldLines = append(oldLines, op.Line)
+			}
+			if op.Kind != Delete {
+				newLines = append(newLines, op.Line)
+			}
+		}
+		if act := strings.Join(oldLines, ""); act != test.a {
+			t.Errorf("Lines(%q, %q): old side %q", test.a, test.b, act)
+		}
+		if act := strings.Join(newLines, ""); act != test.b {
+			t.Errorf("Lines(%q, %q): new side %q", test.a, test.b, act)
+		}
+	}
+}
+
+func TestUnifiedHunks(t *testing.T) {
+	numbers := func(n int, changes map[int]string) []byte {
+		var buffer strings.Builder
+		for i := 1; i <= n; i++ {
+			if line, ok := changes[i]; ok {
+				buffer.WriteString(line + "\n")
+			} else {
+				fmt.Fprintf(&buffer, "%d\n", i)
+			}
+		}
+		return []byte(buffer.String())
+	}
+	testdata := []struct {
+		name     string
+		a, b     []byte
+		expected string
+	}{
+		{
+			// 6 unchanged lines between changes: context of both changes, one hunk
+			"gap of 6 lines",
+			numbers(12, nil), numbers(12, map[int]string{1: "x", 8: "y"}),
+			"@@ -1,11 +1,11 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+y\n 9\n 10\n 11\n",
+		},
+		{
+			"gap of 7 lines",
+			numbers(13, nil), numbers(13, map[int]string{1: "x", 9: "y"}),
+			"@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -6,7 +6,7 @@\n 6\n 7\n 8\n-9\n+y\n 10\n 11\n 12\n",
+		},
+	}
+	for _, test := range testdata {
+		var buffer strings.Builder
+		if err := Unified(&buffer, "a", "b", test.a, test.b); err != nil {
+			t.Fatalf("Unified() failed: %v", err)
+		}
+		expected := "--- a\n+++ b\n" + test.expected
+		if act := buffer.String(); act != expected {
+			t.Errorf("Incorrect diff with %s:\nexpected:\n%s\nactual:\n%s", test.name, expected, act)
+		}
+	}
+}
@@ -13,6 +13,16 @@ func (c FsChanges) WasRemoved(path string) bool {
 	return false
 }
 
+// Kind returns kind of the path change, ok is false if path was not changed.
+func (c FsChanges) Kind(path string) (kind FsChangeKind, ok bool) {
+	for _, ch := range c {
+		if ch.Path == path {
+			return ch.Kind, true
+		}
+	}
+	return 0, false
+}
+
 type FsChange struct {
 	Path string       `json:"Path"`
 	Kind FsChangeKind `json:"Kind"`
@@ -16,6 +16,8 @@ type httpClient interface {
 	Get(string) (*http.Response, error)
 	Head(string) (*http.Response, error)
 	Put(url, contentType string, body io.Reader) (resp *http.Response, err error)
+	Post(url, contentType string, body io.Reader) (resp *http.Response, err error)
+	Delete(url string) (resp *http.Response, err error)
 }
 
 var _ = httpClient((*clientImpl)(nil))
@@ -65,6 +67,20 @@ func (c *clientImpl) Put(url, contentType string, body io.Reader) (*http.Respons
 	return checkResponse(http.MethodPut, url, resp, err)
 }
 
+func (c *clientImpl) Post(url, contentType string, body io.Reader) (*http.Response, error) {
+	resp, err := c.cl.Post(c.addr+url, contentType, body)
+	return checkResponse(http.MethodPost, url, resp, err)
+}
+
+func (c *clientImpl) Delete(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodDelete, c.addr+url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.cl.Do(req)
+	return checkResponse(http.MethodDelete, url, resp, err)
+}
+
 func checkResponse(method, url string, resp *http.Response, err error) (*http.Response, error) {
 	if err != nil {
 		return nil, err
@@ -73,14 +89,23 @@ func checkResponse(method, url string, resp *http.Response, err error) (*http.Re
 		resp.Body.Close()
 		return nil, ErrorNotFound{}
 	}
-	if resp.StatusCode != http.StatusOK {
+	// 201 Created, 204 No Content are fine as well
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		msg, _ := ioutil.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("Unexpected status code on %v %q (expected 200 OK): %v (%s)", method, url, http.StatusText(resp.StatusCode), msg)
+		return nil, fmt.Errorf("Unexpected status code on %v %q (expected 2xx): %v (%s)", method, url, http.StatusText(resp.StatusCode), msg)
 	}
 	return resp, nil
 }
 
+type ErrorNotRunning struct {
+	Id string
+}
+
+func (e ErrorNotRunning) Error() string {
+	return fmt.Sprintf("Container %v is not running", e.Id)
+}
+
 type ErrorNotFound struct {
 }
 
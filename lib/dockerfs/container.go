@@ -10,6 +10,11 @@ type Container struct {
 	Names   []string
 	Image   string
 	Command string
+	// created, running, paused, restarting, exited...
+	State string
+	// Human readable status, e.g. "Exited (0) 2 hours ago"
+	Status string
+	Labels map[string]string
 }
 
 func (c *Container) String() string {
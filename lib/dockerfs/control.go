@@ -0,0 +1,220 @@
+package dockerfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/plesk/docker-fs/lib/log"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Name of the hidden control directory in the mount root.
+// It's not listed by Readdir, so it's never copied along with container files.
+const ControlDirName = ".dockerfs"
+
+var _ = (fs.NodeOnAdder)((*ControlDir)(nil))
+var _ = (fs.NodeGetattrer)((*ControlDir)(nil))
+
+var _ = (fs.NodeOpener)((*ControlFile)(nil))
+var _ = (fs.NodeReader)((*ControlFile)(nil))
+var _ = (fs.NodeGetattrer)((*ControlFile)(nil))
+
+// ControlDir is a virtual read-only directory with container metadata.
+type ControlDir struct {
+	fs.Inode
+	mng *Mng
+
+	fullpath string
+	entries  map[string]fs.InodeEmbedder
+	// prefix of inode keys of the entries, see Mng.inodePrefix
+	inodePrefix string
+}
+
+func (m *Mng) controlDir() *ControlDir {
+	dir := &ControlDir{
+		mng:         m,
+		fullpath:    "/" + ControlDirName,
+		inodePrefix: m.inodePrefix,
+	}
+	dir.entries = map[string]fs.InodeEmbedder{
+		"inspect.json": dir.file("inspect.json", m.inspectJSON),
+		"changes.json": dir.file("changes.json", m.changesJSON),
+		"top.txt":      dir.file("top.txt", m.topTxt),
+		"env":          dir.file("env", m.env),
+		"stats.json":   dir.file("stats.json", m.statsJSON),
+		"logs":         m.logsDir(filepath.Join(dir.fullpath, "logs")),
+	}
+	if m.opts.DiffView {
+		dir.entries["diff"] = m.diffDir()
+	}
+	return dir
+}
+
+func (c *ControlDir) file(name string, content func() ([]byte, error)) *ControlFile {
+	return &ControlFile{
+		mng:      c.mng,
+		fullpath: filepath.Join(c.fullpath, name),
+		content:  content,
+	}
+}
+
+func (c *ControlDir) OnAdd(ctx context.Context) {
+	for name, entry := range c.entries {
+		attr := fs.StableAttr{Ino: c.mng.inodes.Inode(filepath.Join(c.inodePrefix, c.fullpath, name))}
+		switch entry.(type) {
+		case *ControlDir, *Dir:
+			attr.Mode = fuse.S_IFDIR
+		}
+		c.AddChild(name, c.NewPersistentInode(ctx, entry, attr), false)
+	}
+}
+
+func (c *ControlDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Owner.Uid, out.Owner.Gid = c.mng.opts.Uid, c.mng.opts.Gid
+	out.Mode = 0555
+	return 0
+}
+
+// ControlFile is a virtual read-only file, its content is generated on every open.
+type ControlFile struct {
+	fs.Inode
+	mng *Mng
+
+	fullpath string
+	content  func() ([]byte, error)
+
+	// size of the last generated content
+	size  uint64
+	mutex sync.Mutex
+}
+
+// controlHandle keeps content generated on open.
+type controlHandle struct {
+	data []byte
+}
+
+func (f *ControlFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, syserr syscall.Errno) {
+	defer log.Printf("[debug] ControlFile (%s) Open(%o): %v", f.fullpath, flags, syserr)
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EACCES
+	}
+	data, err := f.content()
+	if err != nil {
+		log.Printf("[error] Failed to generate content of %q: %v", f.fullpath, err)
+		return nil, 0, syscall.EIO
+	}
+	f.mutex.Lock()
+	f.size = uint64(len(data))
+	f.mutex.Unlock()
+	// size reported by Getattr may be outdated, so bypass page cache
+	return &controlHandle{data: data}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (f *ControlFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h, ok := fh.(*controlHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	if off >= int64(len(h.data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := off + int64(len(dest))
+	if end > int64(len(h.data)) {
+		end = int64(len(h.data))
+	}
+	return fuse.ReadResultData(h.data[off:end]), 0
+}
+
+func (f *ControlFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	out.Owner.Uid, out.Owner.Gid = f.mng.opts.Uid, f.mng.opts.Gid
+	out.Mode = 0444
+	out.Nlink = 1
+	out.Size = f.size
+	return 0
+}
+
+func (m *Mng) inspectJSON() ([]byte, error) {
+	data, err := m.docker.ContainerInspectRaw(false)
+	if err != nil {
+		return nil, err
+	}
+	return indentJSON(data)
+}
+
+func (m *Mng) statsJSON() ([]byte, error) {
+	data, err := m.docker.ContainerStats()
+	if err != nil {
+		return nil, err
+	}
+	return indentJSON(data)
+}
+
+func (m *Mng) changesJSON() ([]byte, error) {
+	changes, err := m.FsChanges()
+	if err != nil {
+		return nil, err
+	}
+	type change struct {
+		Path string
+		Kind string
+	}
+	list := []change{}
+	for _, ch := range changes {
+		list = append(list, change{Path: ch.Path, Kind: ch.Kind.String()})
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (m *Mng) topTxt() ([]byte, error) {
+	top, err := m.docker.ContainerTop()
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	w := tabwriter.NewWriter(&buffer, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(top.Titles, "\t"))
+	for _, proc := range top.Processes {
+		fmt.Fprintln(w, strings.Join(proc, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (m *Mng) env() ([]byte, error) {
+	info, err := m.docker.ContainerInspect(false)
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	for _, v := range info.Config.Env {
+		buffer.WriteString(v)
+		buffer.WriteByte('\n')
+	}
+	return buffer.Bytes(), nil
+}
+
+func indentJSON(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := json.Indent(&buffer, data, "", "  "); err != nil {
+		return nil, err
+	}
+	buffer.WriteByte('\n')
+	return buffer.Bytes(), nil
+}
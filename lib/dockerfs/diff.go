@@ -0,0 +1,104 @@
+package dockerfs
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/plesk/docker-fs/lib/log"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+const (
+	// Diff view mirrors only added and modified paths of container
+	diffViewPath = "/" + ControlDirName + "/diff"
+	// List of removed paths in the root of diff view
+	removedListName = "removed.txt"
+)
+
+func (m *Mng) diffDir() *Dir {
+	return &Dir{
+		mng:      m,
+		fullpath: "/",
+		diff:     true,
+	}
+}
+
+// DiffInDir returns added and modified direct children of the directory
+// along with their file types (S_IFDIR, S_IFREG...).
+func (m *Mng) DiffInDir(dir string) (map[string]uint32, error) {
+	changes, err := m.FsChanges()
+	if err != nil {
+		return nil, err
+	}
+
+	dir = filepath.Clean(dir)
+	prefix := dir
+	if prefix != "/" {
+		prefix += "/"
+	}
+	children := make(map[string]uint32)
+	for _, change := range changes {
+		if change.Kind == FileRemoved || !strings.HasPrefix(change.Path, prefix) {
+			continue
+		}
+		sub := change.Path[len(prefix):]
+		if pos := strings.Index(sub, "/"); pos >= 0 {
+			// Deeper path, parent directory may be not reported
+			children[sub[:pos]] = fuse.S_IFDIR
+			continue
+		}
+		if _, ok := children[sub]; ok {
+			continue
+		}
+		stat, err := m.content.GetPathAttrs(change.Path)
+		if err != nil {
+			if !errors.As(err, &ErrorNotFound{}) {
+				log.Printf("[error] Failed to get raw attrs of %q: %v", change.Path, err)
+			}
+			continue
+		}
+		children[sub] = fuseMode(stat.Mode) & syscall.S_IFMT
+	}
+	return children, nil
+}
+
+func (d *Dir) readdirDiff() (fs.DirStream, syscall.Errno) {
+	children, err := d.mng.DiffInDir(d.fullpath)
+	if err != nil {
+		log.Printf("[error] Cannot retrieve FS changes: %v", err)
+		return nil, syscall.EIO
+	}
+	if d.fullpath == "/" {
+		children[removedListName] = fuse.S_IFREG
+	}
+
+	var list []fuse.DirEntry
+	for child, mode := range children {
+		list = append(list, fuse.DirEntry{
+			Mode: mode,
+			Name: child,
+			Ino:  d.mng.inodes.Inode(d.inodeKey(filepath.Join(d.fullpath, child))),
+		})
+	}
+	return fs.NewListDirStream(list), 0
+}
+
+func (m *Mng) removedTxt() ([]byte, error) {
+	changes, err := m.FsChanges()
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	for _, ch := range changes {
+		if ch.Kind == FileRemoved {
+			buffer.WriteString(ch.Path)
+			buffer.WriteByte('\n')
+		}
+	}
+	return buffer.Bytes(), nil
+}
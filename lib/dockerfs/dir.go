@@ -18,18 +18,34 @@ var _ = (fs.NodeGetattrer)((*Dir)(nil))
 var _ = (fs.NodeLookuper)((*Dir)(nil))
 var _ = (fs.NodeReaddirer)((*Dir)(nil))
 var _ = (fs.NodeCreater)((*Dir)(nil))
+var _ = (fs.NodeMknoder)((*Dir)(nil))
+var _ = (fs.NodeSymlinker)((*Dir)(nil))
+var _ = (fs.NodeStatfser)((*Dir)(nil))
+var _ = (fs.NodeGetxattrer)((*Dir)(nil))
+var _ = (fs.NodeListxattrer)((*Dir)(nil))
 
 type Dir struct {
 	fs.Inode
 	mng *Mng
 
 	fullpath string
+	// node of the diff view: only changed files are shown, read-only
+	diff bool
+}
+
+// Returns the key used to generate inode number of the path.
+// Diff view and image layers show the same paths, but they must not share inodes with each other.
+func (d *Dir) inodeKey(path string) string {
+	if d.diff {
+		path = filepath.Join(diffViewPath, path)
+	}
+	return filepath.Join(d.mng.inodePrefix, path)
 }
 
 func (d *Dir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (err syscall.Errno) {
 	defer log.Printf("[debug] Dir (%s) Getattr(): %v", d.fullpath, err)
-	out.Owner.Uid = d.mng.uid
-	out.Owner.Gid = d.mng.gid
+	out.Owner.Uid = d.mng.opts.Uid
+	out.Owner.Gid = d.mng.opts.Gid
 	out.Mode = 0755
 	return 0
 }
@@ -38,7 +54,34 @@ func (d *Dir) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (n *f
 	defer log.Printf("[debug] Dir (%s) Lookup(%s): %v", d.fullpath, name, syserr)
 	path := filepath.Join(d.fullpath, name)
 
-	attrs, err := d.mng.docker.GetPathAttrs(path)
+	if path == "/"+ControlDirName && !d.diff && !d.mng.noControlDir {
+		control := d.mng.controlDir()
+		return d.NewPersistentInode(ctx, control, fs.StableAttr{Mode: fuse.S_IFDIR, Ino: d.mng.inodes.Inode(d.inodeKey(path))}), 0
+	}
+
+	if d.diff {
+		if path == "/"+removedListName {
+			removed := &ControlFile{mng: d.mng, fullpath: diffViewPath + path, content: d.mng.removedTxt}
+			return d.NewPersistentInode(ctx, removed, fs.StableAttr{Ino: d.mng.inodes.Inode(d.inodeKey(path))}), 0
+		}
+		children, err := d.mng.DiffInDir(d.fullpath)
+		if err != nil {
+			log.Printf("[error] Cannot retrieve FS changes: %v", err)
+			return nil, syscall.EIO
+		}
+		if _, ok := children[name]; !ok {
+			return nil, syscall.ENOENT
+		}
+	}
+
+	// directory could be replaced with a symlink after it was looked up
+	dir, err := d.mng.ResolvePath(d.fullpath)
+	if err != nil {
+		log.Printf("[error] Failed to resolve %q: %v", d.fullpath, err)
+		return nil, resolveErrno(err)
+	}
+
+	attrs, err := d.mng.pathAttrs(filepath.Join(dir, name))
 	if errors.As(err, &ErrorNotFound{}) {
 		return nil, syscall.ENOENT
 	}
@@ -49,23 +92,64 @@ func (d *Dir) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (n *f
 	mode := attrs.Mode
 	log.Printf("[trace] (%s) Lookup(%s): mode = %o", d.fullpath, name, mode)
 
-	out.Owner.Uid, out.Owner.Gid = d.mng.uid, d.mng.gid
+	out.Owner.Uid, out.Owner.Gid = d.mng.opts.Uid, d.mng.opts.Gid
 
-	inode := d.mng.inodes.Inode(filepath.Clean(path))
+	inode := d.mng.inodes.Inode(d.inodeKey(path))
 	if (mode & os.ModeSymlink) != 0 {
-		linkTarget := attrs.LinkTarget
-		return d.NewPersistentInode(ctx, &fs.MemSymlink{Data: []byte(linkTarget)}, fs.StableAttr{Mode: fuse.S_IFLNK, Ino: inode}), 0
+		link := &Symlink{mng: d.mng, fullpath: path, target: []byte(d.mng.mountLinkTarget(attrs.LinkTarget))}
+		return d.NewPersistentInode(ctx, link, fs.StableAttr{Mode: fuse.S_IFLNK, Ino: inode}), 0
 	}
 
 	if mode.IsDir() {
-		return d.NewPersistentInode(ctx, &Dir{mng: d.mng, fullpath: path}, fs.StableAttr{Mode: fuse.S_IFDIR, Ino: inode}), 0
+		return d.NewPersistentInode(ctx, &Dir{mng: d.mng, fullpath: path, diff: d.diff}, fs.StableAttr{Mode: fuse.S_IFDIR, Ino: inode}), 0
+	}
+
+	if fileType := fuseMode(mode) & syscall.S_IFMT; fileType != fuse.S_IFREG {
+		// FIFOs, sockets and devices
+		return d.NewPersistentInode(ctx, &Special{mng: d.mng, fullpath: path}, fs.StableAttr{Mode: fileType, Ino: inode}), 0
+	}
+
+	return d.NewPersistentInode(ctx, &File{mng: d.mng, fullpath: path, diff: d.diff}, fs.StableAttr{Ino: inode}), 0
+}
+
+// Only named pipes can be created.
+func (d *Dir) Mknod(ctx context.Context, name string, mode uint32, dev uint32, out *fuse.EntryOut) (node *fs.Inode, errno syscall.Errno) {
+	defer log.Printf("[debug] Dir (%s) Mknod(%q, mode=%o, dev=%d): %v", d.fullpath, name, mode, dev, errno)
+	if d.diff {
+		return nil, syscall.EROFS
+	}
+	if mode&syscall.S_IFMT != syscall.S_IFIFO {
+		return nil, syscall.EPERM
+	}
+	path := filepath.Join(d.fullpath, name)
+	_, syserr := d.Lookup(ctx, name, &fuse.EntryOut{})
+	if syserr == 0 {
+		return nil, syscall.EEXIST
+	}
+	if syserr != syscall.ENOENT {
+		return nil, syserr
+	}
+
+	resolved, err := d.mng.WritablePath(path)
+	if err != nil {
+		log.Printf("[error] Cannot create %q: %v", path, err)
+		return nil, resolveErrno(err)
+	}
+	if err := d.mng.docker.MakeFifo(resolved, mode&07777); err != nil {
+		log.Printf("[error] Failed to create named pipe %q: %v", path, err)
+		return nil, syscall.EIO
 	}
 
-	return d.NewPersistentInode(ctx, &File{mng: d.mng, fullpath: path}, fs.StableAttr{Ino: inode}), 0
+	inode := d.mng.inodes.Inode(d.inodeKey(path))
+	return d.NewPersistentInode(ctx, &Special{mng: d.mng, fullpath: path}, fs.StableAttr{Mode: fuse.S_IFIFO, Ino: inode}), 0
 }
 
 func (d *Dir) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (node *fs.Inode, fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
 	defer log.Printf("[debug] Dir (%s) Create(%q, flags=%o, mode=%o, ...): %v", d.fullpath, name, flags, mode, errno)
+	if d.diff {
+		errno = syscall.EROFS
+		return
+	}
 	path := filepath.Join(d.fullpath, name)
 	// check if file exist
 	_, syserr := d.Lookup(ctx, name, &fuse.EntryOut{})
@@ -80,6 +164,11 @@ func (d *Dir) Create(ctx context.Context, name string, flags uint32, mode uint32
 		errno = syserr
 		return
 	}
+	if _, err := d.mng.WritablePath(path); err != nil {
+		log.Printf("[error] Cannot create %q: %v", path, err)
+		errno = resolveErrno(err)
+		return
+	}
 
 	f := &File{
 		mng:      d.mng,
@@ -91,14 +180,48 @@ func (d *Dir) Create(ctx context.Context, name string, flags uint32, mode uint32
 		},
 	}
 
-	inode := d.mng.inodes.Inode(filepath.Clean(path))
+	inode := d.mng.inodes.Inode(d.inodeKey(path))
 
 	node = d.NewPersistentInode(ctx, f, fs.StableAttr{Ino: inode})
+	d.mng.fileWritten(f, true)
+	fh = &fileHandle{flags: flags}
 	return
 }
 
+func (d *Dir) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (node *fs.Inode, errno syscall.Errno) {
+	defer log.Printf("[debug] Dir (%s) Symlink(%q, %q): %v", d.fullpath, target, name, errno)
+	if d.diff {
+		return nil, syscall.EROFS
+	}
+	path := filepath.Join(d.fullpath, name)
+	_, syserr := d.Lookup(ctx, name, &fuse.EntryOut{})
+	if syserr == 0 {
+		return nil, syscall.EEXIST
+	}
+	if syserr != syscall.ENOENT {
+		return nil, syserr
+	}
+
+	resolved, err := d.mng.WritablePath(path)
+	if err != nil {
+		log.Printf("[error] Cannot create %q: %v", path, err)
+		return nil, resolveErrno(err)
+	}
+	if err := d.mng.docker.MakeSymlink(resolved, d.mng.containerLinkTarget(target)); err != nil {
+		log.Printf("[error] Failed to create symlink %q: %v", path, err)
+		return nil, syscall.EIO
+	}
+
+	inode := d.mng.inodes.Inode(d.inodeKey(path))
+	link := &Symlink{mng: d.mng, fullpath: path, target: []byte(target)}
+	return d.NewPersistentInode(ctx, link, fs.StableAttr{Mode: fuse.S_IFLNK, Ino: inode}), 0
+}
+
 func (d *Dir) Readdir(ctx context.Context) (ds fs.DirStream, syserr syscall.Errno) {
 	defer log.Printf("[debug] Dir (%s) Readdir(): %v", d.fullpath, syserr)
+	if d.diff {
+		return d.readdirDiff()
+	}
 	children := make(map[string]uint32)
 	path := d.fullpath
 	if path != "/" {
@@ -112,7 +235,7 @@ func (d *Dir) Readdir(ctx context.Context) (ds fs.DirStream, syserr syscall.Errn
 	}
 
 	// check static files and removed ones
-	for name, mode := range d.mng.staticFiles {
+	for name, static := range d.mng.contentOf(d.fullpath) {
 		if !strings.HasPrefix(name, path) {
 			continue
 		}
@@ -126,12 +249,8 @@ func (d *Dir) Readdir(ctx context.Context) (ds fs.DirStream, syserr syscall.Errn
 			log.Printf("[trace] Readdir (1): children[%v] = %o", sub[:pos], fuse.S_IFDIR)
 			children[sub[:pos]] = fuse.S_IFDIR
 		} else if pos < 0 {
-			log.Printf("[trace] Readdir (2): children[%v] = %o", sub, uint32(mode))
-			if (mode & fuse.S_IFLNK) == fuse.S_IFLNK {
-				children[sub] = fuse.S_IFLNK
-			} else {
-				children[sub] = fuse.S_IFREG
-			}
+			log.Printf("[trace] Readdir (2): children[%v] = %o", sub, fuseMode(static.mode))
+			children[sub] = fuseMode(static.mode) & syscall.S_IFMT
 		}
 	}
 
@@ -141,16 +260,12 @@ func (d *Dir) Readdir(ctx context.Context) (ds fs.DirStream, syserr syscall.Errn
 			continue
 		}
 		log.Printf("[trace] Readdir (3): childred[%v] = %o", filepath.Base(ch.Path), ch.mode)
-		fuseMode := uint32(fuse.S_IFREG)
-		if os.FileMode(ch.mode).IsDir() {
-			fuseMode = fuse.S_IFDIR
-		}
-		children[filepath.Base(ch.Path)] = fuseMode
+		children[filepath.Base(ch.Path)] = fuseMode(os.FileMode(ch.mode)) & syscall.S_IFMT
 	}
 
 	var list []fuse.DirEntry
 	for child, mode := range children {
-		inode := d.mng.inodes.Inode(filepath.Clean(filepath.Join(d.fullpath, child)))
+		inode := d.mng.inodes.Inode(d.inodeKey(filepath.Join(d.fullpath, child)))
 		list = append(list, fuse.DirEntry{
 			Mode: mode,
 			Name: child,
@@ -159,3 +274,24 @@ func (d *Dir) Readdir(ctx context.Context) (ds fs.DirStream, syserr syscall.Errn
 	}
 	return fs.NewListDirStream(list), 0
 }
+
+func (d *Dir) Getxattr(ctx context.Context, attr string, dest []byte) (n uint32, syserr syscall.Errno) {
+	defer log.Printf("[debug] Dir (%s) Getxattr(%q): %d, %v", d.fullpath, attr, n, syserr)
+	return d.mng.getxattr(d.fullpath, attr, dest)
+}
+
+func (d *Dir) Listxattr(ctx context.Context, dest []byte) (n uint32, syserr syscall.Errno) {
+	defer log.Printf("[debug] Dir (%s) Listxattr(): %d, %v", d.fullpath, n, syserr)
+	return d.mng.listxattr(d.fullpath, dest)
+}
+
+func (d *Dir) Statfs(ctx context.Context, out *fuse.StatfsOut) (syserr syscall.Errno) {
+	defer log.Printf("[debug] Dir (%s) Statfs(): %v", d.fullpath, syserr)
+	statfs, err := d.mng.Statfs()
+	if err != nil {
+		log.Printf("[error] Failed to get FS usage: %v", err)
+		return syscall.EIO
+	}
+	*out = *statfs
+	return 0
+}
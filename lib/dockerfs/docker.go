@@ -9,15 +9,17 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	neturl "net/url"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
-type dockerMng interface {
-	// returns read-closer to tar-archive fetched by /containers/{id}/export api method
-	ContainerExport() (io.ReadCloser, error)
-
+// DockerMng works with a single container through docker API.
+// ContentReader reads files of the mounted FS, it's all that static read-only content
+// (e.g. an image layer) has to provide.
+type ContentReader interface {
 	GetPathAttrs(path string) (*ContainerPathStat, error)
 
 	GetFsChanges() (FsChanges, error)
@@ -25,29 +27,103 @@ type dockerMng interface {
 	// Get plain file content
 	GetFile(path string) (io.ReadCloser, error)
 
+	// Get extended attributes stored in tar PAX headers of /containers/{id}/archive.
+	// Archive of a directory includes all its content, so it's not requested for directories.
+	GetPathXattrs(path string) (map[string]string, error)
+}
+
+type DockerMng interface {
+	ContentReader
+
+	// returns read-closer to tar-archive fetched by /containers/{id}/export api method
+	ContainerExport() (io.ReadCloser, error)
+
 	// Save file
 	SaveFile(path string, data []byte, stat *ContainerPathStat) (err error)
 
+	// Create named pipe
+	MakeFifo(path string, mode uint32) error
+
+	// Create symbolic link
+	MakeSymlink(path, target string) error
+
+	// Create directory
+	MakeDir(path string, mode uint32) error
+
+	// Run command in container, returns its combined stdout and stderr.
+	// ErrorNotRunning is returned if container is stopped.
+	Exec(cmd []string) (output []byte, exitCode int, err error)
+
 	// List containers
 	ContainersList() ([]Container, error)
+
+	// Container details (/containers/{id}/json), size requests SizeRw and SizeRootFs calculation
+	ContainerInspect(size bool) (*ContainerInfo, error)
+
+	// Container details as they are returned by docker
+	ContainerInspectRaw(size bool) ([]byte, error)
+
+	// Processes running in container
+	ContainerTop() (*ContainerTop, error)
+
+	// Single resource usage statistics entry as returned by docker
+	ContainerStats() ([]byte, error)
+
+	// Container stdout and stderr (multiplexed unless container has TTY)
+	ContainerLogs(follow bool) (io.ReadCloser, error)
+
+	// Create (but not start) a new container from the image, returns its ID
+	ContainerCreate(image string) (string, error)
+
+	// Remove container along with its anonymous volumes
+	ContainerRemove() error
+
+	// Docker system information
+	Info() (*DockerInfo, error)
+
+	// Image with its layers in `docker save` format
+	ImageSave(image string) (io.ReadCloser, error)
+
+	// Tar archive of the path (directories are archived recursively)
+	GetArchive(path string) (io.ReadCloser, error)
+
+	// Stream of JSON encoded container events
+	Events() (io.ReadCloser, error)
+
+	// Point to another container (e.g. the recreated one)
+	SetContainerId(id string)
 }
 
-var _ = (dockerMng)((*dockerMngImpl)(nil))
+var _ = (DockerMng)((*dockerMngImpl)(nil))
 
 type dockerMngImpl struct {
 	httpc httpClient
-	id    string
+	// container can be recreated with a new ID, see SetContainerId
+	id      string
+	idMutex sync.RWMutex
 }
 
-func NewDockerMng(httpc httpClient, containerId string) dockerMng {
+func NewDockerMng(httpc httpClient, containerId string) DockerMng {
 	return &dockerMngImpl{
 		httpc: httpc,
 		id:    containerId,
 	}
 }
 
+func (d *dockerMngImpl) containerId() string {
+	d.idMutex.RLock()
+	defer d.idMutex.RUnlock()
+	return d.id
+}
+
+func (d *dockerMngImpl) SetContainerId(id string) {
+	d.idMutex.Lock()
+	defer d.idMutex.Unlock()
+	d.id = id
+}
+
 func (d *dockerMngImpl) ContainerExport() (io.ReadCloser, error) {
-	resp, err := d.httpc.Get("/containers/" + d.id + "/export")
+	resp, err := d.httpc.Get("/containers/" + d.containerId() + "/export")
 	if err != nil {
 		return nil, err
 	}
@@ -55,7 +131,7 @@ func (d *dockerMngImpl) ContainerExport() (io.ReadCloser, error) {
 }
 
 func (d *dockerMngImpl) GetPathAttrs(path string) (*ContainerPathStat, error) {
-	url := "/containers/" + d.id + "/archive?path=" + path
+	url := "/containers/" + d.containerId() + "/archive?path=" + path
 	resp, err := d.httpc.Head(url)
 	if err != nil {
 		return nil, fmt.Errorf("Head request to %q failed: %w", url, err)
@@ -73,7 +149,7 @@ func (d *dockerMngImpl) GetPathAttrs(path string) (*ContainerPathStat, error) {
 }
 
 func (d *dockerMngImpl) GetFsChanges() (FsChanges, error) {
-	resp, err := d.httpc.Get("/containers/" + d.id + "/changes")
+	resp, err := d.httpc.Get("/containers/" + d.containerId() + "/changes")
 	if err != nil {
 		return nil, err
 	}
@@ -87,7 +163,7 @@ func (d *dockerMngImpl) GetFsChanges() (FsChanges, error) {
 }
 
 func (d *dockerMngImpl) GetFile(path string) (io.ReadCloser, error) {
-	url := "/containers/" + d.id + "/archive?path=" + path
+	url := "/containers/" + d.containerId() + "/archive?path=" + path
 	resp, err := d.httpc.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("Head request to %q failed: %w", url, err)
@@ -104,8 +180,45 @@ func (d *dockerMngImpl) GetFile(path string) (io.ReadCloser, error) {
 	}, nil
 }
 
+func (d *dockerMngImpl) GetArchive(path string) (io.ReadCloser, error) {
+	url := "/containers/" + d.containerId() + "/archive?path=" + path
+	resp, err := d.httpc.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Get request to %q failed: %w", url, err)
+	}
+	return resp.Body, nil
+}
+
+// PAX records prefix used for extended attributes
+const paxSchilyXattr = "SCHILY.xattr."
+
+// Headers of the first archive entry are expected to fit into it, the rest of the archive is not read
+const maxPathXattrsSize = 1 << 20
+
+func (d *dockerMngImpl) GetPathXattrs(path string) (map[string]string, error) {
+	url := "/containers/" + d.containerId() + "/archive?path=" + path
+	resp, err := d.httpc.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Get request to %q failed: %w", url, err)
+	}
+	// only the first header is needed, the connection is closed without reading the rest
+	defer resp.Body.Close()
+	hdr, err := tar.NewReader(io.LimitReader(resp.Body, maxPathXattrsSize)).Next()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find file in tar archive: %w", err)
+	}
+	xattrs := make(map[string]string)
+	for key, value := range hdr.PAXRecords {
+		if strings.HasPrefix(key, paxSchilyXattr) {
+			xattrs[key[len(paxSchilyXattr):]] = value
+		}
+	}
+	return xattrs, nil
+}
+
 func (d *dockerMngImpl) ContainersList() ([]Container, error) {
-	url := "/containers/json"
+	// stopped containers are listed as well
+	url := "/containers/json?all=1"
 	resp, err := d.httpc.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("Get request to %q failed: %w", url, err)
@@ -122,6 +235,143 @@ func (d *dockerMngImpl) ContainersList() ([]Container, error) {
 	return cts, nil
 }
 
+func (d *dockerMngImpl) ContainerInspect(size bool) (*ContainerInfo, error) {
+	data, err := d.ContainerInspectRaw(size)
+	if err != nil {
+		return nil, err
+	}
+	info := new(ContainerInfo)
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (d *dockerMngImpl) ContainerInspectRaw(size bool) ([]byte, error) {
+	url := "/containers/" + d.containerId() + "/json"
+	if size {
+		url += "?size=1"
+	}
+	return d.getRaw(url)
+}
+
+func (d *dockerMngImpl) ContainerTop() (*ContainerTop, error) {
+	if err := d.checkRunning(); err != nil {
+		return nil, err
+	}
+	top := new(ContainerTop)
+	if err := d.getJSON("/containers/"+d.containerId()+"/top", top); err != nil {
+		return nil, err
+	}
+	return top, nil
+}
+
+func (d *dockerMngImpl) ContainerStats() ([]byte, error) {
+	return d.getRaw("/containers/" + d.containerId() + "/stats?stream=false")
+}
+
+func (d *dockerMngImpl) Info() (*DockerInfo, error) {
+	info := new(DockerInfo)
+	if err := d.getJSON("/info", info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (d *dockerMngImpl) ContainerLogs(follow bool) (io.ReadCloser, error) {
+	url := "/containers/" + d.containerId() + "/logs?stdout=1&stderr=1"
+	if follow {
+		url += "&follow=1"
+	}
+	resp, err := d.httpc.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Get request to %q failed: %w", url, err)
+	}
+	return resp.Body, nil
+}
+
+func (d *dockerMngImpl) ContainerCreate(image string) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"Image": image,
+		// container is never started, but command must be specified
+		"Cmd": []string{"/bin/true"},
+	})
+	if err != nil {
+		return "", err
+	}
+	url := "/containers/create"
+	resp, err := d.httpc.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("Post request to %q failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+	var created struct {
+		Id string `json:"Id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", err
+	}
+	return created.Id, nil
+}
+
+func (d *dockerMngImpl) ContainerRemove() error {
+	url := "/containers/" + d.containerId() + "?v=1&force=1"
+	resp, err := d.httpc.Delete(url)
+	if err != nil {
+		return fmt.Errorf("Delete request to %q failed: %w", url, err)
+	}
+	return resp.Body.Close()
+}
+
+func (d *dockerMngImpl) ImageSave(image string) (io.ReadCloser, error) {
+	url := "/images/" + image + "/get"
+	resp, err := d.httpc.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Get request to %q failed: %w", url, err)
+	}
+	return resp.Body, nil
+}
+
+// Processes can be executed only in running (and not paused) containers,
+// docker reports it with 409 Conflict which is hard to tell from other errors.
+func (d *dockerMngImpl) checkRunning() error {
+	info, err := d.ContainerInspect(false)
+	if err != nil {
+		return err
+	}
+	if !info.State.canExec() {
+		return ErrorNotRunning{Id: d.containerId()}
+	}
+	return nil
+}
+
+func (d *dockerMngImpl) Events() (io.ReadCloser, error) {
+	url := "/events?filters=" + neturl.QueryEscape(`{"type":["container"]}`)
+	resp, err := d.httpc.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Get request to %q failed: %w", url, err)
+	}
+	return resp.Body, nil
+}
+
+func (d *dockerMngImpl) getRaw(url string) ([]byte, error) {
+	resp, err := d.httpc.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Get request to %q failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (d *dockerMngImpl) getJSON(url string, v interface{}) error {
+	resp, err := d.httpc.Get(url)
+	if err != nil {
+		return fmt.Errorf("Get request to %q failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
 type readCloser struct {
 	reader io.Reader
 	close  func() error
@@ -145,15 +395,91 @@ func (d *dockerMngImpl) SaveFile(path string, data []byte, stat *ContainerPathSt
 		}
 	}
 
-	var buffer bytes.Buffer
-	writer := tar.NewWriter(&buffer)
-	dir, name := filepath.Split(path)
-	hdr := &tar.Header{
-		Name:    name,
+	return d.putArchive(path, &tar.Header{
 		Size:    int64(len(data)),
 		Mode:    int64(stat.Mode),
 		ModTime: time.Now(),
+	}, data)
+}
+
+func (d *dockerMngImpl) MakeFifo(path string, mode uint32) error {
+	return d.putArchive(path, &tar.Header{
+		Typeflag: tar.TypeFifo,
+		Mode:     int64(mode),
+		ModTime:  time.Now(),
+	}, nil)
+}
+
+func (d *dockerMngImpl) MakeSymlink(path, target string) error {
+	return d.putArchive(path, &tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Linkname: target,
+		Mode:     0777,
+		ModTime:  time.Now(),
+	}, nil)
+}
+
+func (d *dockerMngImpl) MakeDir(path string, mode uint32) error {
+	return d.putArchive(filepath.Clean(path), &tar.Header{
+		Typeflag: tar.TypeDir,
+		Mode:     int64(mode),
+		ModTime:  time.Now(),
+	}, nil)
+}
+
+func (d *dockerMngImpl) Exec(cmd []string) ([]byte, int, error) {
+	if err := d.checkRunning(); err != nil {
+		return nil, 0, err
 	}
+	body, err := json.Marshal(map[string]interface{}{
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Cmd":          cmd,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	url := "/containers/" + d.containerId() + "/exec"
+	resp, err := d.httpc.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, fmt.Errorf("Post request to %q failed: %w", url, err)
+	}
+	var created struct {
+		Id string `json:"Id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	url = "/exec/" + created.Id + "/start"
+	resp, err = d.httpc.Post(url, "application/json", strings.NewReader(`{"Detach":false,"Tty":false}`))
+	if err != nil {
+		return nil, 0, fmt.Errorf("Post request to %q failed: %w", url, err)
+	}
+	var output bytes.Buffer
+	err = demuxLogs(resp.Body, &output, &output)
+	resp.Body.Close()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var inspect struct {
+		ExitCode int `json:"ExitCode"`
+	}
+	if err := d.getJSON("/exec/"+created.Id+"/json", &inspect); err != nil {
+		return nil, 0, err
+	}
+	return output.Bytes(), inspect.ExitCode, nil
+}
+
+// Upload single file to the container, hdr.Name is set from path.
+func (d *dockerMngImpl) putArchive(path string, hdr *tar.Header, data []byte) error {
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+	dir, name := filepath.Split(path)
+	hdr.Name = name
 	if err := writer.WriteHeader(hdr); err != nil {
 		return err
 	}
@@ -164,7 +490,7 @@ func (d *dockerMngImpl) SaveFile(path string, data []byte, stat *ContainerPathSt
 		return err
 	}
 
-	url := "/containers/" + d.id + "/archive?path=" + dir
-	_, err = d.httpc.Put(url, http.DetectContentType(buffer.Bytes()), &buffer)
+	url := "/containers/" + d.containerId() + "/archive?path=" + dir
+	_, err := d.httpc.Put(url, http.DetectContentType(buffer.Bytes()), &buffer)
 	return err
 }
@@ -3,6 +3,7 @@ package dockerfs
 import (
 	"archive/tar"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,14 +11,34 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 )
 
 type dockerMngMock struct {
 	//
 	root string
+
+	// container ID set by SetContainerId
+	id string
+	// events written by tests
+	events *io.PipeWriter
+	mutex  sync.Mutex
+
+	// returned by ContainersList
+	containers []Container
+	// state of the container, running if it's empty
+	state string
+	// mounts of the container, the default one is used if it's nil
+	mounts []MountPoint
+	// number of ContainerLogs calls
+	logsRequests int
+	// paths requested by GetArchive and GetPathXattrs
+	archives []string
+	xattrs   []string
 }
 
-var _ = (dockerMng)((*dockerMngMock)(nil))
+var _ = (DockerMng)((*dockerMngMock)(nil))
 
 func newDockerMngMock() *dockerMngMock {
 	_, file, _, ok := runtime.Caller(0)
@@ -113,13 +134,18 @@ func (d *dockerMngMock) GetPathAttrs(path string) (st *ContainerPathStat, err er
 	if err != nil {
 		return nil, err
 	}
-	return &ContainerPathStat{
+	st = &ContainerPathStat{
 		Name:  fi.Name(),
 		Size:  fi.Size(),
 		Mode:  fi.Mode(),
 		Mtime: fi.ModTime(),
-		// TODO handle link target
-	}, nil
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if st.LinkTarget, err = os.Readlink(fullpath); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
 }
 
 func (d *dockerMngMock) GetFsChanges() (changes FsChanges, err error) {
@@ -150,6 +176,58 @@ func (d *dockerMngMock) GetFsChanges() (changes FsChanges, err error) {
 	return changes, err
 }
 
+// Archive of the directory, names are relative to its parent.
+func (d *dockerMngMock) GetArchive(path string) (io.ReadCloser, error) {
+	d.mutex.Lock()
+	d.archives = append(d.archives, path)
+	d.mutex.Unlock()
+	fullpath := filepath.Join(d.root, path)
+	if _, err := os.Lstat(fullpath + suffixAdded); err == nil {
+		fullpath += suffixAdded
+	}
+	base := filepath.Base(path)
+	buffer := &bytes.Buffer{}
+	tw := tar.NewWriter(buffer)
+	err := filepath.Walk(fullpath, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(fullpath, file)
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(base, strings.Replace(rel, suffixAdded, "", -1))
+		header, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if _, err := tw.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, ErrorNotFound{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(buffer), nil
+}
+
 // Get plain file content
 func (d *dockerMngMock) GetFile(path string) (io.ReadCloser, error) {
 	// Check if file was added
@@ -173,6 +251,16 @@ func (d *dockerMngMock) GetFile(path string) (io.ReadCloser, error) {
 	return f, err
 }
 
+func (d *dockerMngMock) GetPathXattrs(path string) (map[string]string, error) {
+	d.mutex.Lock()
+	d.xattrs = append(d.xattrs, path)
+	d.mutex.Unlock()
+	if _, err := d.GetPathAttrs(path); err != nil {
+		return nil, err
+	}
+	return map[string]string{}, nil
+}
+
 // Save file
 func (d *dockerMngMock) SaveFile(path string, data []byte, stat *ContainerPathStat) (err error) {
 	// Only modification is supported at the moment
@@ -185,6 +273,171 @@ func (d *dockerMngMock) SaveFile(path string, data []byte, stat *ContainerPathSt
 	return err
 }
 
+func (d *dockerMngMock) MakeFifo(path string, mode uint32) error {
+	return syscall.Mkfifo(filepath.Join(d.root, path), mode)
+}
+
+func (d *dockerMngMock) MakeSymlink(path, target string) error {
+	return os.Symlink(target, filepath.Join(d.root, path))
+}
+
+func (d *dockerMngMock) MakeDir(path string, mode uint32) error {
+	return os.Mkdir(filepath.Join(d.root, path), os.FileMode(mode))
+}
+
+func (d *dockerMngMock) Exec(cmd []string) ([]byte, int, error) {
+	if err := d.checkRunning(); err != nil {
+		return nil, 0, err
+	}
+	return nil, 0, fmt.Errorf("Not implemented")
+}
+
 func (d *dockerMngMock) ContainersList() ([]Container, error) {
-	return nil, nil
+	return d.containers, nil
+}
+
+func (d *dockerMngMock) ContainerInspect(size bool) (*ContainerInfo, error) {
+	state := d.state
+	if state == "" {
+		state = "running"
+	}
+	info := &ContainerInfo{
+		Id:   "0001",
+		Name: "/mock",
+		State: ContainerState{
+			Status:  state,
+			Running: state == "running" || state == "paused",
+			Paused:  state == "paused",
+		},
+		Config: ContainerConfig{
+			Env: []string{"PATH=/usr/bin:/bin", "MOCK=1"},
+		},
+		Mounts: []MountPoint{
+			{
+				Type: "bind",
+				// not accessible locally, so content is fetched with GetArchive
+				Source:      "/host/dir3",
+				Destination: "/dir3",
+				RW:          true,
+			},
+		},
+	}
+	if d.mounts != nil {
+		info.Mounts = d.mounts
+	}
+	if !size {
+		return info, nil
+	}
+	err := filepath.Walk(d.root, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(file, suffixAdded) {
+			info.SizeRw += fi.Size()
+		}
+		info.SizeRootFs += fi.Size()
+		return nil
+	})
+	return info, err
+}
+
+func (d *dockerMngMock) ContainerInspectRaw(size bool) ([]byte, error) {
+	info, err := d.ContainerInspect(size)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(info)
+}
+
+func (d *dockerMngMock) ContainerTop() (*ContainerTop, error) {
+	if err := d.checkRunning(); err != nil {
+		return nil, err
+	}
+	return &ContainerTop{
+		Titles:    []string{"PID", "CMD"},
+		Processes: [][]string{{"1", "/bin/sleep infinity"}},
+	}, nil
+}
+
+func (d *dockerMngMock) ContainerStats() ([]byte, error) {
+	return []byte(`{"read":"2020-01-01T00:00:00Z"}`), nil
+}
+
+func (d *dockerMngMock) ContainerLogs(follow bool) (io.ReadCloser, error) {
+	d.mutex.Lock()
+	d.logsRequests++
+	d.mutex.Unlock()
+	buffer := &bytes.Buffer{}
+	for _, frame := range []struct {
+		stream byte
+		data   string
+	}{
+		{1, "stdout line 1\n"},
+		{2, "stderr line 1\n"},
+		{1, "stdout line 2\n"},
+	} {
+		header := []byte{frame.stream, 0, 0, 0, 0, 0, 0, byte(len(frame.data))}
+		buffer.Write(header)
+		buffer.WriteString(frame.data)
+	}
+	return ioutil.NopCloser(buffer), nil
+}
+
+func (d *dockerMngMock) ContainerCreate(image string) (string, error) {
+	return "", fmt.Errorf("Not implemented")
+}
+
+func (d *dockerMngMock) ContainerRemove() error {
+	return fmt.Errorf("Not implemented")
+}
+
+func (d *dockerMngMock) ImageSave(image string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("Not implemented")
+}
+
+func (d *dockerMngMock) Events() (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	d.mutex.Lock()
+	d.events = writer
+	d.mutex.Unlock()
+	return reader, nil
+}
+
+// Sends event to the stream returned by Events.
+func (d *dockerMngMock) sendEvent(event ContainerEvent) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.events == nil {
+		return fmt.Errorf("Events are not followed")
+	}
+	return json.NewEncoder(d.events).Encode(event)
+}
+
+func (d *dockerMngMock) checkRunning() error {
+	info, err := d.ContainerInspect(false)
+	if err != nil {
+		return err
+	}
+	if !info.State.canExec() {
+		return ErrorNotRunning{Id: d.containerId()}
+	}
+	return nil
+}
+
+func (d *dockerMngMock) SetContainerId(id string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.id = id
+}
+
+func (d *dockerMngMock) containerId() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.id
+}
+
+func (d *dockerMngMock) Info() (*DockerInfo, error) {
+	return &DockerInfo{
+		DockerRootDir: d.root,
+	}, nil
 }
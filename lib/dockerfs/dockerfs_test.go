@@ -1,11 +1,21 @@
 package dockerfs
 
 import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/plesk/docker-fs/lib/log"
 
@@ -24,6 +34,7 @@ var (
 	server     *fuse.Server
 	mountPoint string
 	dockerMock *dockerMngMock
+	testMng    *Mng
 )
 
 func setup() {
@@ -32,20 +43,42 @@ func setup() {
 		panic(fmt.Errorf("Cannot create test mount point: %v", err))
 	}
 	mountPoint = dir
+	log.SetLogLevel(log.Debug)
 
-	mng := NewMng("0001")
+	opts := DefaultOptions()
+	opts.DiffView = true
+	mng := NewMng("0001", opts)
 	dockerMock = newDockerMngMock()
 	mng.docker = dockerMock
+	testMng = mng
 	if err := mng.Init(); err != nil {
 		panic(fmt.Errorf("mng.Init() failed: %v", err))
 	}
 	root := mng.Root()
-	server, err = fs.Mount(mountPoint, root, &fs.Options{})
+	server, err = fs.Mount(mountPoint, root, mng.MountOptions())
 	if err != nil {
 		panic(fmt.Errorf("fs.Mount(...) failed: %v", err))
 	}
+}
 
-	log.Level = log.Debug
+// Mounts FS of the mock container with options which differ from the shared mount ones,
+// options of the shared mount can't be changed while it's served.
+func mountTestMng(t *testing.T, dir string, opts Options) (docker *dockerMngMock, unmount func()) {
+	mng := NewMng("0001", opts)
+	docker = newDockerMngMock()
+	mng.docker = docker
+	if err := mng.Init(); err != nil {
+		t.Fatalf("mng.Init() failed: %v", err)
+	}
+	server, err := fs.Mount(dir, mng.Root(), mng.MountOptions())
+	if err != nil {
+		mng.Close()
+		t.Fatalf("fs.Mount(...) failed: %v", err)
+	}
+	return docker, func() {
+		server.Unmount()
+		mng.Close()
+	}
 }
 
 func shutdown() {
@@ -158,3 +191,1159 @@ func TestCreateWriteRegularFile(t *testing.T) {
 		t.Errorf("Cleanup failed: %v", err)
 	}
 }
+
+func TestFlock(t *testing.T) {
+	path := filepath.Join(mountPoint, "file1.txt")
+	f1, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("os.Open(%q) failed: %v", path, err)
+	}
+	defer f1.Close()
+	f2, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("os.Open(%q) failed: %v", path, err)
+	}
+	defer f2.Close()
+
+	if err := syscall.Flock(int(f1.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatalf("Flock(f1, LOCK_EX) failed: %v", err)
+	}
+	if err := syscall.Flock(int(f2.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != syscall.EWOULDBLOCK {
+		t.Errorf("Flock(f2, LOCK_EX): expected %v, actual %v", syscall.EWOULDBLOCK, err)
+	}
+
+	locked := make(chan error)
+	go func() {
+		locked <- syscall.Flock(int(f2.Fd()), syscall.LOCK_EX)
+	}()
+	select {
+	case err := <-locked:
+		t.Fatalf("Flock(f2, LOCK_EX) was not blocked: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Closing the file releases its lock
+	if err := f1.Close(); err != nil {
+		t.Fatalf("f1.Close() failed: %v", err)
+	}
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Errorf("Flock(f2, LOCK_EX) failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Flock(f2, LOCK_EX) is still blocked after f1 was closed")
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	testdata := []struct {
+		path, attr, value string
+	}{
+		{"file1.txt", XattrChange, "unchanged"},
+		{"file3.txt", XattrChange, "added"},
+		{"dir3/file5.txt", XattrChange, "added"},
+		{"dir3/file5.txt", XattrMount, "bind /host/dir3 rw"},
+		{"dir2", XattrContainer, "0001"},
+		{"file1.txt", XattrContainer, "0001"},
+	}
+	for _, test := range testdata {
+		t.Run(test.path+":"+test.attr, func(t *testing.T) {
+			file := filepath.Join(mountPoint, test.path)
+			dest := make([]byte, 256)
+			n, err := syscall.Getxattr(file, test.attr, dest)
+			if err != nil {
+				t.Fatalf("Getxattr(%q, %q) failed: %v", file, test.attr, err)
+			}
+			if act, exp := string(dest[:n]), test.value; act != exp {
+				t.Errorf("Incorrect attribute value: expected %q, actual %q", exp, act)
+			}
+		})
+	}
+
+	file := filepath.Join(mountPoint, "file1.txt")
+	dest := make([]byte, 1024)
+	n, err := syscall.Listxattr(file, dest)
+	if err != nil {
+		t.Fatalf("Listxattr(%q) failed: %v", file, err)
+	}
+	if list := string(dest[:n]); !strings.Contains(list, XattrUpdated+"\x00") {
+		t.Errorf("Attribute %q is not listed: %q", XattrUpdated, list)
+	}
+}
+
+func TestXattrsCache(t *testing.T) {
+	docker := newDockerMngMock()
+	mng := NewMng("0001", DefaultOptions())
+	mng.docker = docker
+	mng.xattrsCacheSize = 2
+	if err := mng.Init(); err != nil {
+		t.Fatalf("mng.Init() failed: %v", err)
+	}
+	paths := []string{"/file1.txt", "/dir2", "/file3.txt", "/dir2/file2.txt"}
+	for _, path := range paths {
+		if _, err := mng.Xattrs(path); err != nil {
+			t.Fatalf("Xattrs(%q) failed: %v", path, err)
+		}
+		if len(mng.xattrs) > mng.xattrsCacheSize {
+			t.Errorf("Attributes of %d paths are cached: %v", len(mng.xattrs), mng.xattrs)
+		}
+	}
+	if _, ok := mng.xattrs["/dir2/file2.txt"]; !ok {
+		t.Errorf("Attributes of the last path are not cached")
+	}
+	// archive of a directory includes all its content
+	expected := []string{"/file1.txt", "/file3.txt", "/dir2/file2.txt"}
+	if !reflect.DeepEqual(docker.xattrs, expected) {
+		t.Errorf("Incorrect paths requested for PAX headers: expected %v, actual %v", expected, docker.xattrs)
+	}
+}
+
+func TestMkfifo(t *testing.T) {
+	name := "new_fifo"
+	path := filepath.Join(mountPoint, name)
+	if err := syscall.Mkfifo(path, 0640); err != nil {
+		t.Fatalf("Mkfifo(%q) failed: %v", path, err)
+	}
+	defer func() {
+		// Cleanup
+		if err := os.Remove(filepath.Join(dockerMock.root, name)); err != nil {
+			t.Errorf("Cleanup failed: %v", err)
+		}
+	}()
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("os.Lstat(%q) failed: %v", path, err)
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("Incorrect file mode: expected named pipe, actual %v", fi.Mode())
+	}
+	if err := syscall.Mknod(filepath.Join(mountPoint, "new_dev"), syscall.S_IFCHR|0640, 0x0103); err != syscall.EPERM {
+		t.Errorf("Mknod(S_IFCHR): expected %v, actual %v", syscall.EPERM, err)
+	}
+}
+
+func TestStatfs(t *testing.T) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(mountPoint, &st); err != nil {
+		t.Fatalf("Statfs(%q) failed: %v", mountPoint, err)
+	}
+	if st.Blocks == 0 || st.Bsize == 0 {
+		t.Errorf("Empty FS usage reported: %+v", st)
+	}
+	if st.Blocks < st.Bfree {
+		t.Errorf("Free blocks exceed total: %+v", st)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	name := "new_link"
+	path := filepath.Join(mountPoint, name)
+	target := "/dir2/file2.txt"
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatalf("os.Symlink(%q, %q) failed: %v", target, path, err)
+	}
+	defer func() {
+		// Cleanup
+		if err := os.Remove(filepath.Join(dockerMock.root, name)); err != nil {
+			t.Errorf("Cleanup failed: %v", err)
+		}
+	}()
+
+	// Targets are not rewritten by default
+	for _, link := range []string{path, filepath.Join(dockerMock.root, name)} {
+		stored, err := os.Readlink(link)
+		if err != nil {
+			t.Fatalf("os.Readlink() failed: %v", err)
+		}
+		if act, exp := stored, target; act != exp {
+			t.Errorf("Incorrect symlink target of %q: expected %q, actual %q", link, exp, act)
+		}
+	}
+}
+
+func TestRewriteSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dockerfs_symlinks_")
+	if err != nil {
+		t.Fatalf("Cannot create mount point: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	opts := DefaultOptions()
+	opts.SymlinkRoot = dir
+	docker, unmount := mountTestMng(t, dir, opts)
+	defer unmount()
+
+	name := "new_link"
+	path := filepath.Join(dir, name)
+	target := filepath.Join(dir, "dir2/file2.txt")
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatalf("os.Symlink(%q, %q) failed: %v", target, path, err)
+	}
+	defer func() {
+		// Cleanup
+		if err := os.Remove(filepath.Join(docker.root, name)); err != nil {
+			t.Errorf("Cleanup failed: %v", err)
+		}
+	}()
+
+	// Target is stored relative to container root
+	stored, err := os.Readlink(filepath.Join(docker.root, name))
+	if err != nil {
+		t.Fatalf("os.Readlink() failed: %v", err)
+	}
+	if act, exp := stored, "/dir2/file2.txt"; act != exp {
+		t.Errorf("Incorrect symlink target in container: expected %q, actual %q", exp, act)
+	}
+
+	// and points inside mount point
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%q) failed: %v", path, err)
+	}
+	if act, exp := string(content), "file2\n"; act != exp {
+		t.Errorf("Incorrect file content: expected %q, actual %q", exp, act)
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	links := map[string]string{
+		"link1.added": "/dir2",
+		"link2.added": "link1/../dir3",
+		"loop1.added": "/loop2",
+		"loop2.added": "loop1",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dockerMock.root, name)); err != nil {
+			t.Fatalf("os.Symlink() failed: %v", err)
+		}
+		defer os.Remove(filepath.Join(dockerMock.root, name))
+	}
+	// drop cached changes
+	testMng.changesMutex.Lock()
+	testMng.changes = nil
+	testMng.changesMutex.Unlock()
+
+	testdata := []struct {
+		path, resolved string
+	}{
+		{"/dir2/file2.txt", "/dir2/file2.txt"},
+		{"/link1/file2.txt", "/dir2/file2.txt"},
+		{"/link2/file5.txt", "/dir3/file5.txt"},
+		{"/link1/missing/file", "/dir2/missing/file"},
+	}
+	for _, test := range testdata {
+		resolved, err := testMng.ResolvePath(test.path)
+		if err != nil {
+			t.Errorf("ResolvePath(%q) failed: %v", test.path, err)
+		} else if resolved != test.resolved {
+			t.Errorf("ResolvePath(%q): expected %q, actual %q", test.path, test.resolved, resolved)
+		}
+	}
+
+	if _, err := testMng.ResolvePath("/loop1/file"); !errors.As(err, &ErrorLoop{}) {
+		t.Errorf("ResolvePath() of symlinks loop: expected %v, actual %v", ErrorLoop{}, err)
+	}
+}
+
+func TestWriteBindMount(t *testing.T) {
+	// dir3 is bind-mounted from host
+	path := filepath.Join(mountPoint, "dir3/file6.txt")
+	err := ioutil.WriteFile(path, []byte("file6\n"), 0644)
+	if !errors.Is(err, syscall.EROFS) {
+		t.Errorf("ioutil.WriteFile(%q): expected %v, actual %v", path, syscall.EROFS, err)
+	}
+
+	// read-only mounts can't be written even if bind writes are allowed
+	opts := DefaultOptions()
+	opts.AllowBindWrites = true
+	mng := NewMng("0001", opts)
+	mng.docker = newDockerMngMock()
+	if err := mng.Init(); err != nil {
+		t.Fatalf("mng.Init() failed: %v", err)
+	}
+	mng.mounts = append([]MountPoint{{Type: "volume", Name: "data", Destination: "/dir3/data"}}, mng.containerMounts()...)
+	if _, err := mng.WritablePath("/dir3/data/file"); !errors.As(err, &ErrorReadOnly{}) {
+		t.Errorf("WritablePath() in read-only volume: expected %v, actual %v", ErrorReadOnly{}, err)
+	}
+	if _, err := mng.WritablePath("/dir3/file6.txt"); err != nil {
+		t.Errorf("WritablePath() in bind mount failed: %v", err)
+	}
+}
+
+func TestMountContentFetchedLazily(t *testing.T) {
+	mng := NewMng("0001", DefaultOptions())
+	docker := newDockerMngMock()
+	mng.docker = docker
+	if err := mng.Init(); err != nil {
+		t.Fatalf("mng.Init() failed: %v", err)
+	}
+	if len(docker.archives) != 0 {
+		t.Errorf("Content of mounts is fetched on mount: %v", docker.archives)
+	}
+	if _, ok := mng.staticFile("/dir3"); !ok {
+		t.Errorf("Mount point /dir3 is not found")
+	}
+	if len(docker.archives) != 0 {
+		t.Errorf("Content of mount is fetched to resolve its mount point: %v", docker.archives)
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := mng.contentOf("/dir3")["/dir3/file5.txt"]; !ok {
+			t.Errorf("File /dir3/file5.txt of the mount is not found")
+		}
+	}
+	if expected := []string{"/dir3"}; fmt.Sprint(docker.archives) != fmt.Sprint(expected) {
+		t.Errorf("Incorrect archives fetched: expected %v, actual %v", expected, docker.archives)
+	}
+}
+
+func TestHostContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dockerfs_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mnt := &MountPoint{Type: "bind", Source: dir, Destination: "/data"}
+
+	content, err := NewMng("0001", DefaultOptions()).hostContent(mnt)
+	if err != nil {
+		t.Fatalf("hostContent() failed: %v", err)
+	}
+	if _, ok := content["/data/file"]; !ok {
+		t.Errorf("File of the host path is not found: %v", content)
+	}
+
+	// host paths of remote engine are not the local ones
+	opts := DefaultOptions()
+	opts.DockerAddr = "tcp://127.0.0.1:2375"
+	if _, err := NewMng("0001", opts).hostContent(mnt); err == nil {
+		t.Errorf("hostContent() succeeded for remote engine")
+	}
+}
+
+func TestHostContentServed(t *testing.T) {
+	host, err := ioutil.TempDir("", "dockerfs_host_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(host)
+	if err := ioutil.WriteFile(filepath.Join(host, "file"), []byte("host file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "dockerfs_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mng := NewMng("0001", DefaultOptions())
+	docker := newDockerMngMock()
+	docker.mounts = []MountPoint{{Type: "bind", Source: host, Destination: "/dir3", RW: true}}
+	mng.docker = docker
+	if err := mng.Init(); err != nil {
+		t.Fatalf("mng.Init() failed: %v", err)
+	}
+	defer mng.Close()
+	server, err := fs.Mount(dir, mng.Root(), mng.MountOptions())
+	if err != nil {
+		t.Fatalf("fs.Mount(...) failed: %v", err)
+	}
+	defer server.Unmount()
+
+	// the file is missing in the container, so it can't be fetched through docker API
+	path := filepath.Join(dir, "dir3/file")
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%q) failed: %v", path, err)
+	}
+	if act, exp := string(content), "host file\n"; act != exp {
+		t.Errorf("Incorrect file content: expected %q, actual %q", exp, act)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat(%q) failed: %v", path, err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("Incorrect mode of %q: expected %v, actual %v", path, os.FileMode(0600), fi.Mode().Perm())
+	}
+	if len(docker.archives) != 0 {
+		t.Errorf("Content of the host path is fetched through docker API: %v", docker.archives)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dockerfs_readonly_")
+	if err != nil {
+		t.Fatalf("Cannot create mount point: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	opts := DefaultOptions()
+	opts.ReadOnly = true
+	_, unmount := mountTestMng(t, dir, opts)
+	defer unmount()
+
+	path := filepath.Join(dir, "file1.txt")
+	if _, err := os.OpenFile(path, os.O_WRONLY, 0); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("os.OpenFile(%q, O_WRONLY): expected %v, actual %v", path, syscall.EROFS, err)
+	}
+	path = filepath.Join(dir, "new_file7.txt")
+	if err := ioutil.WriteFile(path, []byte("file7\n"), 0644); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("ioutil.WriteFile(%q): expected %v, actual %v", path, syscall.EROFS, err)
+	}
+}
+
+func TestControlDir(t *testing.T) {
+	testdata := []struct {
+		name, contains string
+	}{
+		{"inspect.json", `"Id": "0001"`},
+		{"changes.json", `"Kind": "Added"`},
+		{"top.txt", "/bin/sleep infinity"},
+		{"env", "MOCK=1\n"},
+		{"stats.json", `"read"`},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(mountPoint, ControlDirName, test.name)
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatalf("ReadFile(%q) failed: %v", file, err)
+			}
+			if !strings.Contains(string(content), test.contains) {
+				t.Errorf("Content of %q doesn't contain %q: %q", test.name, test.contains, content)
+			}
+		})
+	}
+}
+
+func TestStoppedContainer(t *testing.T) {
+	for _, state := range []string{"exited", "paused"} {
+		t.Run(state, func(t *testing.T) {
+			docker := newDockerMngMock()
+			docker.state = state
+			mng := NewMng("0001", DefaultOptions())
+			mng.docker = docker
+			if err := mng.Init(); err != nil {
+				t.Fatalf("mng.Init() failed: %v", err)
+			}
+			dir, err := ioutil.TempDir("", "dockerfs_stopped_")
+			if err != nil {
+				t.Fatalf("Cannot create mount point: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			server, err := fs.Mount(dir, mng.Root(), mng.MountOptions())
+			if err != nil {
+				t.Fatalf("fs.Mount(...) failed: %v", err)
+			}
+			defer server.Unmount()
+
+			content, err := ioutil.ReadFile(filepath.Join(dir, "file1.txt"))
+			if err != nil {
+				t.Errorf("ReadFile(%q) failed: %v", "file1.txt", err)
+			} else if string(content) != "file1\n" {
+				t.Errorf("Incorrect content of %q: %q", "file1.txt", content)
+			}
+			name := "new_file_" + state + ".txt"
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(state+"\n"), 0644); err != nil {
+				t.Errorf("WriteFile(%q) failed: %v", name, err)
+			} else if err := os.Remove(filepath.Join(docker.root, name)); err != nil {
+				t.Errorf("Cleanup failed: %v", err)
+			}
+
+			// processes can't be listed, see TestCheckRunning for detection of the state
+			if _, err := ioutil.ReadFile(filepath.Join(dir, ControlDirName, "top.txt")); !errors.Is(err, syscall.EIO) {
+				t.Errorf("ReadFile(%q): expected %v, actual %v", "top.txt", syscall.EIO, err)
+			}
+		})
+	}
+}
+
+// Serves inspect and top of container 0001 in the state on unix socket.
+func fakeEngine(t *testing.T, state ContainerState) string {
+	dir, err := ioutil.TempDir("", "dockerfs_engine_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Cannot listen %v: %v", socket, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/0001/json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ContainerInfo{Id: "0001", State: state})
+	})
+	mux.HandleFunc("/containers/0001/top", func(w http.ResponseWriter, r *http.Request) {
+		if !state.canExec() {
+			// docker responds so to stopped and paused containers
+			http.Error(w, `{"message":"Container 0001 is not running"}`, http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(ContainerTop{Titles: []string{"PID"}, Processes: [][]string{{"1"}}})
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return "unix:" + socket
+}
+
+func TestCheckRunning(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		state   ContainerState
+		running bool
+	}{
+		{"running", ContainerState{Status: "running", Running: true}, true},
+		{"paused", ContainerState{Status: "paused", Running: true, Paused: true}, false},
+		{"exited", ContainerState{Status: "exited"}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := NewClient(fakeEngine(t, test.state))
+			if err != nil {
+				t.Fatal(err)
+			}
+			docker := NewDockerMng(client, "0001")
+			_, err = docker.ContainerTop()
+			if test.running && err != nil {
+				t.Errorf("ContainerTop() failed: %v", err)
+			}
+			if !test.running && !errors.As(err, &ErrorNotRunning{}) {
+				t.Errorf("ContainerTop(): expected ErrorNotRunning, actual %v", err)
+			}
+			if !test.running {
+				if _, _, err := docker.Exec([]string{"true"}); !errors.As(err, &ErrorNotRunning{}) {
+					t.Errorf("Exec(): expected ErrorNotRunning, actual %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestContainerLogs(t *testing.T) {
+	testdata := []struct {
+		name, content string
+	}{
+		{"stdout", "stdout line 1\nstdout line 2\n"},
+		{"stderr", "stderr line 1\n"},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(mountPoint, ControlDirName, "logs", test.name)
+			var content []byte
+			// logs are fetched in background
+			for i := 0; i < 50; i++ {
+				var err error
+				content, err = ioutil.ReadFile(file)
+				if err != nil {
+					t.Fatalf("ReadFile(%q) failed: %v", file, err)
+				}
+				if len(content) >= len(test.content) {
+					break
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			if act, exp := string(content), test.content; act != exp {
+				t.Errorf("Incorrect log content: expected %q, actual %q", exp, act)
+			}
+		})
+	}
+}
+
+func TestContainerLogsRestart(t *testing.T) {
+	docker := newDockerMngMock()
+	mng := NewMng("0001", DefaultOptions())
+	mng.docker = docker
+	if err := mng.Init(); err != nil {
+		t.Fatalf("mng.Init() failed: %v", err)
+	}
+	requests := func() int {
+		docker.mutex.Lock()
+		defer docker.mutex.Unlock()
+		return docker.logsRequests
+	}
+	waitFinished := func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mng.logs.mutex.Lock()
+			following := mng.logs.reader != nil
+			mng.logs.mutex.Unlock()
+			if !following {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Logs are still followed")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if err := mng.logs.follow(); err != nil {
+		t.Fatalf("follow() failed: %v", err)
+	}
+	waitFinished()
+	// output of the stopped container is kept
+	if err := mng.logs.follow(); err != nil {
+		t.Fatalf("follow() failed: %v", err)
+	}
+	if n := requests(); n != 1 {
+		t.Errorf("Logs are fetched %d times, expected once", n)
+	}
+	if size := mng.logs.stdout.Size(); size != int64(len("stdout line 1\nstdout line 2\n")) {
+		t.Errorf("Incorrect size of kept output: %d", size)
+	}
+
+	if err := mng.containerStarted("0002"); err != nil {
+		t.Fatalf("containerStarted() failed: %v", err)
+	}
+	if n := requests(); n != 2 {
+		t.Errorf("Logs are not fetched again on start: %d requests", n)
+	}
+	waitFinished()
+
+	mng.Close()
+	if err := mng.containerStarted("0003"); err != nil {
+		t.Fatalf("containerStarted() failed: %v", err)
+	}
+	if n := requests(); n != 2 {
+		t.Errorf("Logs are fetched after close: %d requests", n)
+	}
+}
+
+func TestDiffView(t *testing.T) {
+	root := filepath.Join(mountPoint, ControlDirName, "diff")
+	expFiles := map[string]string{
+		"/file3.txt":      "file3\n",
+		"/dir2/file4.txt": "file4\n",
+		"/dir3/file5.txt": "file5\n",
+		"/removed.txt":    "",
+	}
+	found := 0
+	err := filepath.Walk(root, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			t.Errorf("Error accessing file %q: %v", file, err)
+			return nil
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		file = file[len(root):]
+		exp, ok := expFiles[file]
+		if !ok {
+			t.Errorf("Unexpected file found: %q", file)
+			return nil
+		}
+		found++
+		content, err := ioutil.ReadFile(filepath.Join(root, file))
+		if err != nil {
+			t.Errorf("ReadFile(%q) failed: %v", file, err)
+		} else if string(content) != exp {
+			t.Errorf("Incorrect content of %q: expected %q, actual %q", file, exp, content)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("filepath.Walk(%q, ...) failed: %v", root, err)
+	}
+	if found != len(expFiles) {
+		t.Errorf("Not all files found: expected %d, actual %d", len(expFiles), found)
+	}
+
+	path := filepath.Join(root, "file3.txt")
+	if err := ioutil.WriteFile(path, []byte("changed"), 0644); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("ioutil.WriteFile(%q): expected %v, actual %v", path, syscall.EROFS, err)
+	}
+}
+
+// Writes `docker save` like archive with two layers.
+func writeImageArchive(file *os.File) error {
+	type entry struct {
+		name, content string
+	}
+	tarball := func(entries []entry) []byte {
+		var buffer bytes.Buffer
+		writer := tar.NewWriter(&buffer)
+		for _, e := range entries {
+			hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+			if strings.HasSuffix(e.name, "/") {
+				hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+			}
+			writer.WriteHeader(hdr)
+			writer.Write([]byte(e.content))
+		}
+		writer.Close()
+		return buffer.Bytes()
+	}
+	config := `{"history": [
+		{"created_by": "/bin/sh -c #(nop) ADD file:1a2b in / "},
+		{"created_by": "/bin/sh -c #(nop)  CMD [\"sh\"]", "empty_layer": true},
+		{"created_by": "RUN /bin/sh -c rm /etc/b.txt # buildkit"}
+	]}`
+	archive := []entry{
+		{"manifest.json", `[{"Config": "config.json", "Layers": ["1/layer.tar", "2/layer.tar"]}]`},
+		{"config.json", config},
+		{"1/", ""},
+		{"1/layer.tar", string(tarball([]entry{
+			{"etc/", ""},
+			{"etc/a.txt", "a\n"},
+			{"etc/b.txt", "b\n"},
+			{"dir/", ""},
+			{"dir/x.txt", "x\n"},
+		}))},
+		{"2/", ""},
+		{"2/layer.tar", string(tarball([]entry{
+			{"etc/", ""},
+			{"etc/.wh.b.txt", ""},
+			{"etc/c.txt", "c\n"},
+			{"dir/", ""},
+			{"dir/y.txt", "y\n"},
+			{"dir/.wh..wh..opq", ""},
+		}))},
+	}
+	_, err := file.Write(tarball(archive))
+	return err
+}
+
+func TestImageLayers(t *testing.T) {
+	file, err := ioutil.TempFile("", "dockerfs_image_")
+	if err != nil {
+		t.Fatalf("Cannot create image archive: %v", err)
+	}
+	defer os.Remove(file.Name())
+	if err := writeImageArchive(file); err != nil {
+		t.Fatalf("Cannot write image archive: %v", err)
+	}
+	layers, err := ParseImageArchive(file, "test:latest")
+	if err != nil {
+		t.Fatalf("ParseImageArchive() failed: %v", err)
+	}
+	defer layers.Close()
+
+	dir, err := ioutil.TempDir("", "dockerfs_layers_")
+	if err != nil {
+		t.Fatalf("Cannot create mount point: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	root, mng := layers.Root(DefaultOptions())
+	server, err := fs.Mount(dir, root, mng.MountOptions())
+	if err != nil {
+		t.Fatalf("fs.Mount(...) failed: %v", err)
+	}
+	defer server.Unmount()
+
+	expFiles := map[string]string{
+		"layers/1-ADD_file_1a2b_in/etc/a.txt":        "a\n",
+		"layers/1-ADD_file_1a2b_in/etc/b.txt":        "b\n",
+		"layers/1-ADD_file_1a2b_in/dir/x.txt":        "x\n",
+		"layers/2-RUN_rm_etc_b.txt/etc/.wh.b.txt":    "",
+		"layers/2-RUN_rm_etc_b.txt/etc/c.txt":        "c\n",
+		"layers/2-RUN_rm_etc_b.txt/dir/y.txt":        "y\n",
+		"layers/2-RUN_rm_etc_b.txt/dir/.wh..wh..opq": "",
+		"merged/etc/a.txt":                           "a\n",
+		"merged/etc/c.txt":                           "c\n",
+		"merged/dir/y.txt":                           "y\n",
+	}
+	found := 0
+	err = filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			t.Errorf("Error accessing file %q: %v", file, err)
+			return nil
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		name := file[len(dir)+1:]
+		exp, ok := expFiles[name]
+		if !ok {
+			t.Errorf("Unexpected file found: %q", name)
+			return nil
+		}
+		found++
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Errorf("ReadFile(%q) failed: %v", name, err)
+		} else if string(content) != exp {
+			t.Errorf("Incorrect content of %q: expected %q, actual %q", name, exp, content)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("filepath.Walk(%q, ...) failed: %v", dir, err)
+	}
+	if found != len(expFiles) {
+		t.Errorf("Not all files found: expected %d, actual %d", len(expFiles), found)
+	}
+
+	// used space is the size of layer content, there is no docker to ask
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(filepath.Join(dir, "merged"), &st); err != nil {
+		t.Fatalf("Statfs() of merged layers failed: %v", err)
+	}
+	if st.Blocks == 0 {
+		t.Errorf("Used space of merged layers is not reported: %+v", st)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	if err := testMng.EnableReconnect(); err != nil {
+		t.Fatalf("EnableReconnect() failed: %v", err)
+	}
+	// other tests expect the original container
+	defer testMng.setContainerId(testMng.containerId())
+	defer testMng.disableReconnect()
+	name := "file7.txt"
+	listed := func() bool {
+		names, err := ioutil.ReadDir(mountPoint)
+		if err != nil {
+			t.Fatalf("ioutil.ReadDir(%q) failed: %v", mountPoint, err)
+		}
+		for _, fi := range names {
+			if fi.Name() == name {
+				return true
+			}
+		}
+		return false
+	}
+	// not added through the mount, so it's listed only when content is fetched again
+	if err := ioutil.WriteFile(filepath.Join(dockerMock.root, name), []byte("file7\n"), 0644); err != nil {
+		t.Fatalf("Cannot create %q: %v", name, err)
+	}
+	defer func() {
+		// Cleanup
+		if err := os.Remove(filepath.Join(dockerMock.root, name)); err != nil {
+			t.Errorf("Cleanup failed: %v", err)
+		}
+		if err := testMng.reload(); err != nil {
+			t.Errorf("Cleanup failed: %v", err)
+		}
+	}()
+	if listed() {
+		t.Fatalf("%q is listed before reconnect", name)
+	}
+
+	event := ContainerEvent{Action: "start"}
+	event.Actor.ID = "0002"
+	event.Actor.Attributes = map[string]string{"name": "mock"}
+	// events stream is opened asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := dockerMock.sendEvent(event)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sendEvent() failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for !listed() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%q is not listed after reconnect", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if id := dockerMock.containerId(); id != "0002" {
+		t.Errorf("Container ID is not updated: expected %q, actual %q", "0002", id)
+	}
+	dest := make([]byte, 64)
+	n, err := syscall.Getxattr(filepath.Join(mountPoint, name), XattrContainer, dest)
+	if err != nil {
+		t.Fatalf("Getxattr(%q, %q) failed: %v", name, XattrContainer, err)
+	}
+	if id := string(dest[:n]); id != "0002" {
+		t.Errorf("Container ID of %q is not updated: expected %q, actual %q", name, "0002", id)
+	}
+}
+
+func TestMultiMng(t *testing.T) {
+	docker := newDockerMngMock()
+	docker.containers = []Container{
+		{Id: "0001", Names: []string{"/web"}, State: "running"},
+		{Id: "0002", Names: []string{"/db"}, State: "exited"},
+		{Id: "0003", Names: []string{"/cache"}, State: "running"},
+	}
+	multi := NewMultiMng(SelectContainers([]string{"web", "db"}), DefaultOptions(), nil)
+	multi.docker = docker
+	multi.newDocker = func(id string) DockerMng {
+		return newDockerMngMock()
+	}
+	if err := multi.Init(); err != nil {
+		t.Fatalf("multi.Init() failed: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "dockerfs_multi_")
+	if err != nil {
+		t.Fatalf("Cannot create mount point: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	server, err := fs.Mount(dir, multi.Root(), multi.MountOptions())
+	if err != nil {
+		t.Fatalf("fs.Mount(...) failed: %v", err)
+	}
+	defer server.Unmount()
+
+	listed := func() string {
+		names, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ioutil.ReadDir(%q) failed: %v", dir, err)
+		}
+		var result []string
+		for _, fi := range names {
+			result = append(result, fi.Name())
+		}
+		return strings.Join(result, " ")
+	}
+	waitListed := func(exp string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for listed() != exp {
+			if time.Now().After(deadline) {
+				t.Fatalf("Incorrect containers listed: expected %q, actual %q", exp, listed())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	sendEvent := func(action, id, name string) {
+		event := ContainerEvent{Action: action}
+		event.Actor.ID = id
+		event.Actor.Attributes = map[string]string{"name": name}
+		// events stream is opened asynchronously
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			err := docker.sendEvent(event)
+			if err == nil {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("sendEvent() failed: %v", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitListed("web")
+	content, err := ioutil.ReadFile(filepath.Join(dir, "web/file1.txt"))
+	if err != nil {
+		t.Errorf("ReadFile(%q) failed: %v", "web/file1.txt", err)
+	} else if string(content) != "file1\n" {
+		t.Errorf("Incorrect content of %q: %q", "web/file1.txt", content)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "web", ControlDirName, "inspect.json")); err != nil {
+		t.Errorf("Control directory of container is not accessible: %v", err)
+	}
+
+	sendEvent("start", "0003", "cache")
+	sendEvent("start", "0002", "db")
+	waitListed("db web")
+	sendEvent("die", "0001", "web")
+	waitListed("db")
+	if _, err := os.Stat(filepath.Join(dir, "web")); !os.IsNotExist(err) {
+		t.Errorf("Stopped container is accessible: %v", err)
+	}
+	sendEvent("start", "0004", "web")
+	waitListed("db web")
+}
+
+func TestMultiMngSkipsFailed(t *testing.T) {
+	docker := newDockerMngMock()
+	docker.containers = []Container{
+		{Id: "0001", Names: []string{"/web"}, State: "running"},
+		{Id: "0002", Names: []string{"/broken"}, State: "running"},
+	}
+	multi := NewMultiMng(SelectContainers(nil), DefaultOptions(), nil)
+	multi.docker = docker
+	multi.newDocker = func(id string) DockerMng {
+		mock := newDockerMngMock()
+		if id == "0002" {
+			// content can't be exported
+			mock.root = filepath.Join(mock.root, "missing")
+		}
+		return mock
+	}
+	if err := multi.Init(); err != nil {
+		t.Fatalf("multi.Init() failed: %v", err)
+	}
+	defer multi.Close()
+	if _, ok := multi.running("web"); !ok {
+		t.Errorf("Container web is not mounted")
+	}
+	if _, ok := multi.running("broken"); ok {
+		t.Errorf("Container broken is mounted")
+	}
+	// events are subscribed before containers are listed
+	event := ContainerEvent{Action: "die"}
+	event.Actor.ID = "0001"
+	if err := docker.sendEvent(event); err != nil {
+		t.Errorf("Events are not followed after Init: %v", err)
+	}
+}
+
+func TestMultiMngContainerOptions(t *testing.T) {
+	docker := newDockerMngMock()
+	docker.containers = []Container{
+		{Id: "0001", Names: []string{"/web"}, State: "running"},
+		{Id: "0002", Names: []string{"/db"}, State: "running"},
+	}
+	opts := DefaultOptions()
+	opts.Cache = CacheMetadata
+	multi := NewMultiMng(SelectContainers(nil), opts, func(docker DockerMng) (Options, error) {
+		opts := DefaultOptions()
+		if docker.(*dockerMngMock).containerId() == "0002" {
+			opts.Uid, opts.ReadOnly, opts.Reconnect = 999, true, true
+		}
+		return opts, nil
+	})
+	multi.docker = docker
+	multi.newDocker = func(id string) DockerMng {
+		mock := newDockerMngMock()
+		mock.SetContainerId(id)
+		return mock
+	}
+	if err := multi.Init(); err != nil {
+		t.Fatalf("multi.Init() failed: %v", err)
+	}
+
+	web, ok := multi.running("web")
+	if !ok {
+		t.Fatalf("Container web is not running")
+	}
+	db, ok := multi.running("db")
+	if !ok {
+		t.Fatalf("Container db is not running")
+	}
+	if web.mng.opts.ReadOnly || web.mng.opts.Uid == 999 {
+		t.Errorf("Options of db are applied to web: %+v", web.mng.opts)
+	}
+	if !db.mng.opts.ReadOnly || db.mng.opts.Uid != 999 {
+		t.Errorf("Options of db are not applied: %+v", db.mng.opts)
+	}
+	// containers are not followed by Reconnect and cache mode is common for the whole FS
+	for _, c := range []*multiContainer{web, db} {
+		if c.mng.opts.Reconnect || c.mng.opts.Cache != CacheMetadata {
+			t.Errorf("Incorrect options of container %v: %+v", c.id, c.mng.opts)
+		}
+	}
+}
+
+func TestSelectComposeProject(t *testing.T) {
+	selector := SelectComposeProject("app")
+	tests := []struct {
+		labels map[string]string
+		dir    string
+		ok     bool
+	}{
+		{map[string]string{composeProjectLabel: "app", composeServiceLabel: "web", composeNumberLabel: "1"}, "web", true},
+		{map[string]string{composeProjectLabel: "app", composeServiceLabel: "web", composeNumberLabel: "2"}, "web-2", true},
+		{map[string]string{composeProjectLabel: "app", composeServiceLabel: "db"}, "db", true},
+		{map[string]string{composeProjectLabel: "other", composeServiceLabel: "web"}, "", false},
+		{map[string]string{"name": "app"}, "", false},
+	}
+	for _, test := range tests {
+		dir, ok := selector("container", test.labels)
+		if dir != test.dir || ok != test.ok {
+			t.Errorf("Incorrect selection of %v: expected (%q, %v), actual (%q, %v)", test.labels, test.dir, test.ok, dir, ok)
+		}
+	}
+}
+
+func TestFlushFiles(t *testing.T) {
+	name := "new_file8.txt"
+	file, err := os.Create(filepath.Join(mountPoint, name))
+	if err != nil {
+		t.Fatalf("os.Create(%q) failed: %v", name, err)
+	}
+	defer func() {
+		// Cleanup
+		file.Close()
+		if err := os.Remove(filepath.Join(dockerMock.root, name)); err != nil {
+			t.Errorf("Cleanup failed: %v", err)
+		}
+	}()
+	if _, err := file.Write([]byte("file8\n")); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+
+	// content is saved on close only
+	if err := testMng.FlushFiles(); err != nil {
+		t.Fatalf("FlushFiles() failed: %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dockerMock.root, name))
+	if err != nil {
+		t.Fatalf("File is not saved: %v", err)
+	}
+	if string(content) != "file8\n" {
+		t.Errorf("Incorrect content saved: expected %q, actual %q", "file8\n", content)
+	}
+}
+
+// Saving files before unmount must not race with writes and closes of the files.
+func TestFlushFilesWhileWriting(t *testing.T) {
+	name := "new_file9.txt"
+	defer func() {
+		// Cleanup
+		if err := os.Remove(filepath.Join(dockerMock.root, name)); err != nil {
+			t.Errorf("Cleanup failed: %v", err)
+		}
+	}()
+
+	done := make(chan struct{})
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				testMng.FlushFiles()
+			}
+		}
+	}()
+	expected := strings.Repeat("line\n", 100)
+	for i := 0; i < 10; i++ {
+		// the same content is written again on every open
+		file, err := os.OpenFile(filepath.Join(mountPoint, name), os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatalf("os.OpenFile(%q) failed: %v", name, err)
+		}
+		for j := 0; j < 100; j++ {
+			if _, err := file.Write([]byte("line\n")); err != nil {
+				t.Fatalf("Write() failed: %v", err)
+			}
+		}
+		if err := file.Close(); err != nil {
+			t.Fatalf("Close() failed: %v", err)
+		}
+	}
+	close(done)
+	<-flushed
+
+	content, err := ioutil.ReadFile(filepath.Join(dockerMock.root, name))
+	if err != nil {
+		t.Fatalf("File is not saved: %v", err)
+	}
+	if string(content) != expected {
+		t.Errorf("Incorrect content saved: expected %d bytes, actual %d bytes", len(expected), len(content))
+	}
+}
+
+func TestCacheMode(t *testing.T) {
+	opts := DefaultOptions()
+	if options := NewMng("0001", opts).MountOptions(); options.AttrTimeout != nil || options.EntryTimeout != nil {
+		t.Errorf("Kernel cache is enabled by default: %+v", options)
+	}
+	opts.Cache = CacheMetadata
+	options := NewMng("0001", opts).MountOptions()
+	if options.AttrTimeout == nil || *options.AttrTimeout != metadataCacheTimeout {
+		t.Errorf("Incorrect attributes timeout: expected %v, actual %v", metadataCacheTimeout, options.AttrTimeout)
+	}
+	if options.EntryTimeout == nil || *options.EntryTimeout != metadataCacheTimeout {
+		t.Errorf("Incorrect entries timeout: expected %v, actual %v", metadataCacheTimeout, options.EntryTimeout)
+	}
+	if _, err := ParseCacheMode("all"); err == nil {
+		t.Errorf("ParseCacheMode(%q) succeeded", "all")
+	}
+}
@@ -3,7 +3,10 @@ package dockerfs
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/plesk/docker-fs/lib/log"
@@ -18,22 +21,47 @@ var _ = (fs.NodeWriter)((*File)(nil))
 var _ = (fs.NodeGetattrer)((*File)(nil))
 var _ = (fs.NodeFlusher)((*File)(nil))
 var _ = (fs.NodeFsyncer)((*File)(nil))
+var _ = (fs.NodeReleaser)((*File)(nil))
+var _ = (fs.NodeGetlker)((*File)(nil))
+var _ = (fs.NodeSetlker)((*File)(nil))
+var _ = (fs.NodeSetlkwer)((*File)(nil))
+var _ = (fs.NodeGetxattrer)((*File)(nil))
+var _ = (fs.NodeListxattrer)((*File)(nil))
 
 type File struct {
 	fs.Inode
 	mng *Mng
 
-	fullpath    string
+	fullpath string
+	// guards content and open state, they are changed by writes and flushes
+	// which may come concurrently with FlushFiles
+	mu          sync.Mutex
 	data        []byte
 	read, write bool
 	pos         int64
 	stat        *ContainerPathStat
+	// file of the diff view, read-only
+	diff bool
+}
+
+// fileHandle identifies a single open of the file.
+type fileHandle struct {
+	flags uint32
 }
 
 func (f *File) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, mode uint32, syserr syscall.Errno) {
 	defer log.Printf("[debug] File (%s) Open(%o): %v", f.fullpath, flags, syserr)
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		if f.diff {
+			return nil, 0, syscall.EROFS
+		}
+		if _, err := f.mng.WritablePath(f.fullpath); err != nil {
+			log.Printf("[error] File %q is not writable: %v", f.fullpath, err)
+			return nil, 0, resolveErrno(err)
+		}
+	}
 	// Fetch file content
-	reader, err := f.mng.docker.GetFile(f.fullpath)
+	reader, err := f.mng.fileContent(f.fullpath)
 	if errors.As(err, &ErrorNotFound{}) {
 		return nil, 0, syscall.ENOENT
 	}
@@ -47,11 +75,10 @@ func (f *File) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, mode u
 		log.Printf("[error] Failed to read file from tar archive for %q: %v", f.fullpath, err)
 		return nil, 0, syscall.EIO
 	}
-	f.data = data
 
 	// load mode
 	// TODO make a single API call to retrieve file content and attributes
-	attrs, err := f.mng.docker.GetPathAttrs(f.fullpath)
+	attrs, err := f.mng.pathAttrs(f.fullpath)
 	if errors.As(err, &ErrorNotFound{}) {
 		return nil, 0, syscall.ENOENT
 	}
@@ -59,6 +86,9 @@ func (f *File) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, mode u
 		log.Printf("[error] Failed to get file attributes for %q: %v", f.fullpath, err)
 		return nil, 0, syscall.EIO
 	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data = data
 	f.stat = attrs
 
 	// check flags
@@ -69,6 +99,7 @@ func (f *File) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, mode u
 	if (flags&syscall.O_WRONLY) == syscall.O_WRONLY || (flags&syscall.O_RDWR) == syscall.O_RDWR {
 		log.Printf("[trace] File (%s) write", f.fullpath)
 		f.write = true
+		f.mng.fileWritten(f, true)
 	}
 	if (flags & syscall.O_APPEND) == syscall.O_APPEND {
 		log.Printf("[trace] File (%s) append", f.fullpath)
@@ -78,12 +109,14 @@ func (f *File) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, mode u
 		log.Printf("[trace] File (%s) truncate", f.fullpath)
 		f.data = f.data[:0]
 	}
-	return nil, 0, 0
+	return &fileHandle{flags: flags}, 0, 0
 }
 
 // Read simply returns the data that was already unpacked in the Open call
 func (f *File) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (result fuse.ReadResult, syserr syscall.Errno) {
 	defer log.Printf("[debug] File (%s) Read(%d bytes, offset = %d): %v, %v", f.fullpath, len(dest), off, result, syserr)
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	end := int(off) + len(dest)
 	if end > len(f.data) {
 		end = len(f.data)
@@ -93,7 +126,7 @@ func (f *File) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int6
 
 func (f *File) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (syserr syscall.Errno) {
 	defer log.Printf("[debug] File (%s) Getattr(): %v", f.fullpath, syserr)
-	attrs, err := f.mng.docker.GetPathAttrs(f.fullpath)
+	attrs, err := f.mng.pathAttrs(f.fullpath)
 	if errors.As(err, &ErrorNotFound{}) {
 		return syscall.ENOENT
 	}
@@ -106,20 +139,20 @@ func (f *File) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut)
 	out.Size = uint64(attrs.Size)
 	out.SetTimes(nil, &attrs.Mtime, nil)
 
-	out.Owner.Uid, out.Owner.Gid = f.mng.uid, f.mng.gid
+	out.Owner.Uid, out.Owner.Gid = f.mng.opts.Uid, f.mng.opts.Gid
 	return 0
 }
 
 func (f *File) Write(ctx context.Context, fh fs.FileHandle, data []byte, off int64) (n uint32, syserr syscall.Errno) {
 	defer log.Printf("[debug] File (%s) Write(%d bytes, offset = %d): %d, %v", f.fullpath, len(data), off, n, syserr)
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if !f.write {
 		return 0, syscall.EBADF
 	}
 
 	off += f.pos
 
-	// f.mu.Lock()
-	// defer f.mu.Unlock()
 	end := int64(len(data)) + off
 	if int64(len(f.data)) < end {
 		n := make([]byte, end)
@@ -135,27 +168,112 @@ func (f *File) Write(ctx context.Context, fh fs.FileHandle, data []byte, off int
 // On closing file
 func (f *File) Flush(ctx context.Context, fh fs.FileHandle) (res syscall.Errno) {
 	defer log.Printf("[debug] File (%v) Flush() = %v", f.fullpath, res)
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if !f.write {
 		return 0
 	}
-	if err := f.mng.docker.SaveFile(f.fullpath, f.data, f.stat); err != nil {
-		log.Printf("[error] Failed to save file: %v", err)
-		return syscall.EIO
+	if errno := f.save(); errno != 0 {
+		return errno
 	}
 	// reset/free memory
 	f.data = nil
 	f.read, f.write = false, false
+	f.mng.fileWritten(f, false)
+	return 0
+}
+
+// Saves content to container, f.mu must be held.
+func (f *File) save() syscall.Errno {
+	path, err := f.mng.WritablePath(f.fullpath)
+	if err != nil {
+		log.Printf("[error] File %q is not writable: %v", f.fullpath, err)
+		return resolveErrno(err)
+	}
+	if err := f.mng.docker.SaveFile(path, f.data, f.stat); err != nil {
+		log.Printf("[error] Failed to save file: %v", err)
+		return syscall.EIO
+	}
 	return 0
 }
 
 func (f *File) Fsync(ctx context.Context, fh fs.FileHandle, flags uint32) (res syscall.Errno) {
 	defer log.Printf("[debug] File (%v) Fsync() = %v", f.fullpath, res)
+	return f.saveWritten()
+}
+
+// Saves content if the file is still open for writing, e.g. it isn't flushed meanwhile.
+func (f *File) saveWritten() syscall.Errno {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if !f.write {
 		return 0
 	}
-	if err := f.mng.docker.SaveFile(f.fullpath, f.data, f.stat); err != nil {
-		log.Printf("[error] Failed to save file: %v", err)
-		return syscall.EIO
-	}
+	return f.save()
+}
+
+// On releasing file handle (last close of the open file)
+func (f *File) Release(ctx context.Context, fh fs.FileHandle) (res syscall.Errno) {
+	defer log.Printf("[debug] File (%v) Release() = %v", f.fullpath, res)
+	f.mng.locks.Release(f.StableAttr().Ino, fh)
+	return 0
+}
+
+func (f *File) Getlk(ctx context.Context, fh fs.FileHandle, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) (res syscall.Errno) {
+	defer log.Printf("[debug] File (%v) Getlk(owner=%x, %+v, flags=%x) = %v", f.fullpath, owner, *lk, flags, res)
+	f.mng.locks.Get(f.StableAttr().Ino, owner, lk, flags, out)
 	return 0
 }
+
+func (f *File) Setlk(ctx context.Context, fh fs.FileHandle, owner uint64, lk *fuse.FileLock, flags uint32) (res syscall.Errno) {
+	defer log.Printf("[debug] File (%v) Setlk(owner=%x, %+v, flags=%x) = %v", f.fullpath, owner, *lk, flags, res)
+	return f.mng.locks.Set(f.StableAttr().Ino, owner, fh, lk, flags)
+}
+
+func (f *File) Setlkw(ctx context.Context, fh fs.FileHandle, owner uint64, lk *fuse.FileLock, flags uint32) (res syscall.Errno) {
+	defer log.Printf("[debug] File (%v) Setlkw(owner=%x, %+v, flags=%x) = %v", f.fullpath, owner, *lk, flags, res)
+	return f.mng.locks.SetWait(ctx, f.StableAttr().Ino, owner, fh, lk, flags)
+}
+
+func (f *File) Getxattr(ctx context.Context, attr string, dest []byte) (n uint32, syserr syscall.Errno) {
+	defer log.Printf("[debug] File (%s) Getxattr(%q): %d, %v", f.fullpath, attr, n, syserr)
+	return f.mng.getxattr(f.fullpath, attr, dest)
+}
+
+func (f *File) Listxattr(ctx context.Context, dest []byte) (n uint32, syserr syscall.Errno) {
+	defer log.Printf("[debug] File (%s) Listxattr(): %d, %v", f.fullpath, n, syserr)
+	return f.mng.listxattr(f.fullpath, dest)
+}
+
+// Tracks files opened for writing, so their content can be saved before unmount.
+func (m *Mng) fileWritten(f *File, written bool) {
+	m.filesMutex.Lock()
+	defer m.filesMutex.Unlock()
+	if written {
+		m.writtenFiles[f] = true
+	} else {
+		delete(m.writtenFiles, f)
+	}
+}
+
+// FlushFiles saves content of files opened for writing to container, e.g. before unmount.
+func (m *Mng) FlushFiles() error {
+	m.filesMutex.Lock()
+	files := make([]*File, 0, len(m.writtenFiles))
+	for f := range m.writtenFiles {
+		files = append(files, f)
+	}
+	m.filesMutex.Unlock()
+
+	var failed []string
+	for _, f := range files {
+		log.Printf("[info] Saving %v...", f.fullpath)
+		if errno := f.saveWritten(); errno != 0 {
+			failed = append(failed, f.fullpath)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed to save %v", strings.Join(failed, ", "))
+	}
+	return nil
+}
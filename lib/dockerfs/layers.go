@@ -0,0 +1,448 @@
+package dockerfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/plesk/docker-fs/lib/log"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// Whiteout markers of removed files in image layers (and in bundles of container changes)
+const (
+	WhiteoutPrefix = ".wh."
+	// all lower layers entries of the directory are hidden
+	WhiteoutOpaque = WhiteoutPrefix + ".wh..opq"
+)
+
+// Directories of the image layers mount root
+const (
+	LayersDirName = "layers"
+	MergedDirName = "merged"
+)
+
+// Max length of the layer command in its directory name
+const maxLayerTitle = 48
+
+// ImageLayers is image content saved with `docker save`.
+// Every layer is shown in its own directory with whiteout markers as is,
+// merged directory shows the result of applying all layers.
+type ImageLayers struct {
+	image  string
+	Layers []*Layer
+	Merged *Layer
+
+	// opened archive and decompressed layers, all of them are unlinked
+	files []*os.File
+}
+
+// Layer is a read-only data source for Mng: file attributes are indexed on load
+// and file content is read directly from the archive.
+type Layer struct {
+	// directory name, e.g. 1-ADD_file_in
+	Name string
+	// command the layer was created with
+	CreatedBy string
+
+	image string
+	files map[string]*layerFile
+	size  int64
+}
+
+type layerFile struct {
+	hdr    *tar.Header
+	data   io.ReaderAt
+	offset int64
+}
+
+var _ = (ContentReader)((*Layer)(nil))
+
+// LoadImageLayers saves the image to cache directory and indexes its layers.
+func LoadImageLayers(docker DockerMng, image string) (*ImageLayers, error) {
+	reader, err := docker.ImageSave(image)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	file, err := cacheFile("image_")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return nil, err
+	}
+	layers, err := ParseImageArchive(file, image)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return layers, nil
+}
+
+// ParseImageArchive indexes `docker save` archive: manifest.json, image config and layer tars.
+// Archive must be kept open until the layers are closed.
+func ParseImageArchive(archive *os.File, image string) (*ImageLayers, error) {
+	entries, err := indexTar(archive)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read image archive: %w", err)
+	}
+
+	var manifest []struct {
+		Config string
+		Layers []string
+	}
+	if err := readJSONEntry(entries, "manifest.json", &manifest); err != nil {
+		return nil, err
+	}
+	if len(manifest) == 0 {
+		return nil, fmt.Errorf("Image archive contains no images")
+	}
+	var config struct {
+		History []struct {
+			CreatedBy  string `json:"created_by"`
+			EmptyLayer bool   `json:"empty_layer"`
+		} `json:"history"`
+	}
+	if err := readJSONEntry(entries, manifest[0].Config, &config); err != nil {
+		return nil, err
+	}
+	// history has entries for instructions not creating layers (ENV, CMD etc.) as well
+	var commands []string
+	for _, h := range config.History {
+		if !h.EmptyLayer {
+			commands = append(commands, h.CreatedBy)
+		}
+	}
+
+	result := &ImageLayers{image: image, files: []*os.File{archive}}
+	for i, name := range manifest[0].Layers {
+		entry, ok := entries[filepath.Clean(name)]
+		if !ok {
+			result.Close()
+			return nil, fmt.Errorf("Layer %q is not found in image archive", name)
+		}
+		data, err := result.layerData(entry)
+		if err != nil {
+			result.Close()
+			return nil, fmt.Errorf("Cannot read layer %q: %w", name, err)
+		}
+		files, err := indexTar(data)
+		if err != nil {
+			result.Close()
+			return nil, fmt.Errorf("Cannot read layer %q: %w", name, err)
+		}
+		layer := &Layer{image: image, files: make(map[string]*layerFile)}
+		if i < len(commands) {
+			layer.CreatedBy = commands[i]
+		}
+		layer.Name = fmt.Sprintf("%d-%s", i+1, layerTitle(layer.CreatedBy))
+		for path, file := range files {
+			layer.files["/"+path] = file
+		}
+		layer.resolveHardlinks()
+		layer.updateSize()
+		result.Layers = append(result.Layers, layer)
+	}
+	result.Merged = mergeLayers(image, result.Layers)
+	return result, nil
+}
+
+// Close releases opened archive files.
+func (l *ImageLayers) Close() error {
+	for _, file := range l.files {
+		file.Close()
+	}
+	l.files = nil
+	return nil
+}
+
+// Root returns the mount root with layers and merged directories.
+// Every layer is served by its own read-only Mng, they share inode numbers generator.
+func (l *ImageLayers) Root(opts Options) (fs.InodeEmbedder, *Mng) {
+	inodes := NewIno()
+	merged := l.Merged.mng(inodes, "/"+MergedDirName, opts)
+
+	layersDir := &ControlDir{
+		mng:      merged,
+		fullpath: "/" + LayersDirName,
+		entries:  make(map[string]fs.InodeEmbedder),
+	}
+	for _, layer := range l.Layers {
+		path := filepath.Join(layersDir.fullpath, layer.Name)
+		layersDir.entries[layer.Name] = layer.mng(inodes, path, opts).Root()
+	}
+	root := &ControlDir{
+		mng:      merged,
+		fullpath: "/",
+		entries: map[string]fs.InodeEmbedder{
+			LayersDirName: layersDir,
+			MergedDirName: merged.Root(),
+		},
+	}
+	return root, merged
+}
+
+// Returns ReaderAt of the layer tar, compressed layers are decompressed to cache directory.
+func (l *ImageLayers) layerData(entry *layerFile) (io.ReaderAt, error) {
+	section := io.NewSectionReader(entry.data, entry.offset, entry.hdr.Size)
+	magic := make([]byte, 2)
+	if _, err := section.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if magic[0] != 0x1f || magic[1] != 0x8b {
+		return section, nil
+	}
+
+	reader, err := gzip.NewReader(section)
+	if err != nil {
+		return nil, err
+	}
+	file, err := cacheFile("layer_")
+	if err != nil {
+		return nil, err
+	}
+	l.files = append(l.files, file)
+	if _, err := io.Copy(file, reader); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Creates read-only Mng serving the layer content as a subtree of the mount.
+func (l *Layer) mng(inodes *Ino, prefix string, opts Options) *Mng {
+	opts.ReadOnly, opts.Reconnect = true, false
+	m := NewMng(l.image+"@"+l.Name, opts)
+	m.content = l
+	m.inodes = inodes
+	m.inodePrefix = prefix
+	m.noControlDir = true
+	m.staticFiles = make(map[string]staticFile)
+	for path, file := range l.files {
+		switch file.hdr.Typeflag {
+		case tar.TypeDir:
+			// directories are derived from file paths like for container content
+		case tar.TypeSymlink:
+			m.staticFiles[path] = staticFile{mode: file.hdr.FileInfo().Mode(), link: file.hdr.Linkname}
+		case tar.TypeChar, tar.TypeBlock:
+			m.staticFiles[path] = staticFile{mode: file.hdr.FileInfo().Mode(), rdev: mkdev(file.hdr.Devmajor, file.hdr.Devminor)}
+		default:
+			m.staticFiles[path] = staticFile{mode: file.hdr.FileInfo().Mode()}
+		}
+	}
+	return m
+}
+
+// Hardlinks are shown as regular files with content of their targets.
+func (l *Layer) resolveHardlinks() {
+	for path, file := range l.files {
+		if file.hdr.Typeflag != tar.TypeLink {
+			continue
+		}
+		target, ok := l.files[filepath.Join("/", file.hdr.Linkname)]
+		if !ok {
+			log.Printf("[warning] Target of hardlink %q is not found in layer %s", path, l.Name)
+			delete(l.files, path)
+			continue
+		}
+		hdr := *target.hdr
+		hdr.Name = file.hdr.Name
+		l.files[path] = &layerFile{hdr: &hdr, data: target.data, offset: target.offset}
+	}
+}
+
+func (l *Layer) updateSize() {
+	l.size = 0
+	for _, file := range l.files {
+		l.size += file.hdr.Size
+	}
+}
+
+// Applies layers one by one: whiteouts remove files of lower layers.
+func mergeLayers(image string, layers []*Layer) *Layer {
+	merged := &Layer{Name: MergedDirName, image: image, files: make(map[string]*layerFile)}
+	for _, layer := range layers {
+		// whiteouts affect only lower layers, so they are applied first
+		for path := range layer.files {
+			dir, name := filepath.Split(path)
+			switch {
+			case name == WhiteoutOpaque:
+				merged.removeTree(filepath.Clean(dir), false)
+			case strings.HasPrefix(name, WhiteoutPrefix):
+				merged.removeTree(filepath.Join(dir, name[len(WhiteoutPrefix):]), true)
+			}
+		}
+		for path, file := range layer.files {
+			if strings.HasPrefix(filepath.Base(path), WhiteoutPrefix) {
+				continue
+			}
+			if file.hdr.Typeflag != tar.TypeDir {
+				// file replaces directory of lower layers
+				merged.removeTree(path, false)
+			}
+			merged.files[path] = file
+		}
+	}
+	merged.updateSize()
+	return merged
+}
+
+// Removes directory content, along with directory itself if self is true.
+func (l *Layer) removeTree(path string, self bool) {
+	if self {
+		delete(l.files, path)
+	}
+	for p := range l.files {
+		if isSubPath(p, path) && p != path {
+			delete(l.files, p)
+		}
+	}
+}
+
+var layerTitleRe = regexp.MustCompile(`[^A-Za-z0-9.,=+-]+`)
+
+// Makes a short directory name of the layer command.
+func layerTitle(createdBy string) string {
+	// shell is prepended to RUN commands (after "RUN" by buildkit)
+	title := strings.Replace(createdBy, "/bin/sh -c ", "", 1)
+	title = strings.TrimPrefix(title, "#(nop) ")
+	title = strings.TrimSuffix(strings.TrimSpace(title), "# buildkit")
+	title = strings.Trim(layerTitleRe.ReplaceAllString(title, "_"), "_")
+	if len(title) > maxLayerTitle {
+		title = strings.TrimRight(title[:maxLayerTitle], "_")
+	}
+	if title == "" {
+		return "layer"
+	}
+	return title
+}
+
+// Indexes tar entries with data offsets. Entry paths are cleaned and relative.
+func indexTar(data io.ReaderAt) (map[string]*layerFile, error) {
+	section := io.NewSectionReader(data, 0, 1<<62)
+	reader := tar.NewReader(section)
+	result := make(map[string]*layerFile)
+	for {
+		hdr, err := reader.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// reader stops right at the beginning of entry content
+		offset, err := section.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if path == "." {
+			continue
+		}
+		result[path] = &layerFile{hdr: hdr, data: data, offset: offset}
+	}
+}
+
+func readJSONEntry(entries map[string]*layerFile, name string, v interface{}) error {
+	entry, ok := entries[filepath.Clean(name)]
+	if !ok {
+		return fmt.Errorf("%q is not found in image archive", name)
+	}
+	reader := bufio.NewReader(io.NewSectionReader(entry.data, entry.offset, entry.hdr.Size))
+	if err := json.NewDecoder(reader).Decode(v); err != nil {
+		return fmt.Errorf("Cannot parse %q of image archive: %w", name, err)
+	}
+	return nil
+}
+
+// Creates a temporary file in cache directory, it's removed as soon as it's closed.
+func cacheFile(prefix string) (*os.File, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(home, ".cache/dockerfs")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(file.Name()); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Returns file of the layer or implicit directory if path is a parent of layer files.
+func (l *Layer) lookup(path string) (*tar.Header, error) {
+	path = filepath.Clean(path)
+	if file, ok := l.files[path]; ok {
+		return file.hdr, nil
+	}
+	for p := range l.files {
+		if isSubPath(p, path) {
+			return &tar.Header{Typeflag: tar.TypeDir, Name: path, Mode: 0755}, nil
+		}
+	}
+	return nil, ErrorNotFound{}
+}
+
+func (l *Layer) GetPathAttrs(path string) (*ContainerPathStat, error) {
+	hdr, err := l.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ContainerPathStat{
+		Name:       filepath.Base(path),
+		Size:       hdr.Size,
+		Mode:       hdr.FileInfo().Mode(),
+		Mtime:      hdr.ModTime,
+		LinkTarget: hdr.Linkname,
+	}, nil
+}
+
+// Layer content never changes.
+func (l *Layer) GetFsChanges() (FsChanges, error) {
+	return FsChanges{}, nil
+}
+
+func (l *Layer) GetFile(path string) (io.ReadCloser, error) {
+	file, ok := l.files[filepath.Clean(path)]
+	if !ok {
+		return nil, ErrorNotFound{}
+	}
+	return ioutil.NopCloser(io.NewSectionReader(file.data, file.offset, file.hdr.Size)), nil
+}
+
+func (l *Layer) GetPathXattrs(path string) (map[string]string, error) {
+	hdr, err := l.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	xattrs := make(map[string]string)
+	for key, value := range hdr.PAXRecords {
+		if strings.HasPrefix(key, paxSchilyXattr) {
+			xattrs[key[len(paxSchilyXattr):]] = value
+		}
+	}
+	return xattrs, nil
+}
+
+// Size of the layer content.
+func (l *Layer) Size() int64 {
+	return l.size
+}
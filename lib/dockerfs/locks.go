@@ -0,0 +1,172 @@
+package dockerfs
+
+import (
+	"context"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Locks keeps advisory POSIX (fcntl) and BSD (flock) locks for files of the mounted FS.
+//
+// Locks are only visible to local processes working with the mount point:
+// processes running inside the container know nothing about them
+// and are never blocked by them.
+type Locks struct {
+	locks map[uint64][]lock
+	// closed and replaced each time a lock is released, so blocked waiters can re-check
+	released chan struct{}
+	mutex    sync.Mutex
+}
+
+type lock struct {
+	owner      uint64
+	start, end uint64
+	typ        uint32
+	pid        uint32
+	flock      bool
+	// file handle the lock was taken through (used to drop flock locks on close)
+	fh fs.FileHandle
+}
+
+func NewLocks() *Locks {
+	return &Locks{
+		locks:    make(map[uint64][]lock),
+		released: make(chan struct{}),
+	}
+}
+
+func (l lock) overlaps(start, end uint64) bool {
+	return l.start <= end && start <= l.end
+}
+
+func (l lock) conflicts(owner uint64, lk *fuse.FileLock, flock bool) bool {
+	if l.owner == owner || l.flock != flock {
+		return false
+	}
+	if l.typ != syscall.F_WRLCK && lk.Typ != syscall.F_WRLCK {
+		return false
+	}
+	return l.overlaps(lk.Start, lk.End)
+}
+
+// Get finds a lock which prevents the given one from being placed.
+// If there is no such lock, out.Typ is set to F_UNLCK.
+func (ls *Locks) Get(ino, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	flock := flags&fuse.FUSE_LK_FLOCK != 0
+	for _, l := range ls.locks[ino] {
+		if l.conflicts(owner, lk, flock) {
+			*out = fuse.FileLock{Start: l.start, End: l.end, Typ: l.typ, Pid: l.pid}
+			return
+		}
+	}
+	*out = fuse.FileLock{Typ: syscall.F_UNLCK}
+}
+
+// Set places or releases a lock without waiting.
+// Returns EAGAIN if the lock is held by another owner.
+func (ls *Locks) Set(ino, owner uint64, fh fs.FileHandle, lk *fuse.FileLock, flags uint32) syscall.Errno {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	if !ls.trySet(ino, owner, fh, lk, flags) {
+		return syscall.EAGAIN
+	}
+	return 0
+}
+
+// SetWait places or releases a lock, waiting until conflicting locks are released.
+// Returns EINTR if the request was interrupted.
+func (ls *Locks) SetWait(ctx context.Context, ino, owner uint64, fh fs.FileHandle, lk *fuse.FileLock, flags uint32) syscall.Errno {
+	for {
+		ls.mutex.Lock()
+		if ls.trySet(ino, owner, fh, lk, flags) {
+			ls.mutex.Unlock()
+			return 0
+		}
+		released := ls.released
+		ls.mutex.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return syscall.EINTR
+		}
+	}
+}
+
+// Release drops all flock locks taken through the file handle.
+// POSIX locks are released by the kernel itself, which sends F_UNLCK on close.
+func (ls *Locks) Release(ino uint64, fh fs.FileHandle) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	var kept []lock
+	for _, l := range ls.locks[ino] {
+		if l.flock && l.fh == fh {
+			continue
+		}
+		kept = append(kept, l)
+	}
+	ls.update(ino, kept)
+}
+
+// must be called with mutex held
+func (ls *Locks) trySet(ino, owner uint64, fh fs.FileHandle, lk *fuse.FileLock, flags uint32) bool {
+	flock := flags&fuse.FUSE_LK_FLOCK != 0
+	if lk.Typ != syscall.F_UNLCK {
+		for _, l := range ls.locks[ino] {
+			if l.conflicts(owner, lk, flock) {
+				return false
+			}
+		}
+	}
+
+	// Remove (or cut) owner's locks in the range, then add the new one.
+	var kept []lock
+	for _, l := range ls.locks[ino] {
+		if l.owner != owner || l.flock != flock || !l.overlaps(lk.Start, lk.End) {
+			kept = append(kept, l)
+			continue
+		}
+		if l.start < lk.Start {
+			head := l
+			head.end = lk.Start - 1
+			kept = append(kept, head)
+		}
+		if l.end > lk.End {
+			tail := l
+			tail.start = lk.End + 1
+			kept = append(kept, tail)
+		}
+	}
+	if lk.Typ != syscall.F_UNLCK {
+		kept = append(kept, lock{
+			owner: owner,
+			start: lk.Start,
+			end:   lk.End,
+			typ:   lk.Typ,
+			pid:   lk.Pid,
+			flock: flock,
+			fh:    fh,
+		})
+	}
+	ls.update(ino, kept)
+	return true
+}
+
+// must be called with mutex held
+func (ls *Locks) update(ino uint64, locks []lock) {
+	if len(locks) == 0 {
+		delete(ls.locks, ino)
+	} else {
+		ls.locks[ino] = locks
+	}
+	// wake up waiters: any change may unblock them
+	close(ls.released)
+	ls.released = make(chan struct{})
+}
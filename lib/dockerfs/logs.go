@@ -0,0 +1,275 @@
+package dockerfs
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/plesk/docker-fs/lib/log"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+var _ = (fs.NodeOpener)((*LogFile)(nil))
+var _ = (fs.NodeReader)((*LogFile)(nil))
+var _ = (fs.NodeGetattrer)((*LogFile)(nil))
+
+// Stream types of multiplexed container output
+const (
+	streamStdout = 1
+	streamStderr = 2
+)
+
+// containerLogs follows container output and keeps it in (unlinked) files in cache directory.
+// Following is started on the first open of a log file. Output of the stopped container is kept,
+// it's fetched again from the beginning only when container is started (see Mng.reload).
+type containerLogs struct {
+	mng *Mng
+
+	stdout, stderr *logBuffer
+	// output is fetched, it's being followed while reader is set
+	fetched bool
+	reader  io.ReadCloser
+	closed  bool
+	mutex   sync.Mutex
+}
+
+func newContainerLogs(m *Mng) *containerLogs {
+	return &containerLogs{
+		mng:    m,
+		stdout: &logBuffer{},
+		stderr: &logBuffer{},
+	}
+}
+
+func (m *Mng) logsDir(fullpath string) *ControlDir {
+	return &ControlDir{
+		mng:         m,
+		fullpath:    fullpath,
+		inodePrefix: m.inodePrefix,
+		entries: map[string]fs.InodeEmbedder{
+			"stdout": &LogFile{mng: m, fullpath: filepath.Join(fullpath, "stdout"), logs: m.logs, buffer: m.logs.stdout},
+			"stderr": &LogFile{mng: m, fullpath: filepath.Join(fullpath, "stderr"), logs: m.logs, buffer: m.logs.stderr},
+		},
+	}
+}
+
+// Starts following the output unless it's fetched already.
+func (l *containerLogs) follow() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.fetched || l.closed {
+		return nil
+	}
+	return l.start()
+}
+
+// Fetches the output again if it was fetched, called when container is started.
+func (l *containerLogs) restart() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.fetched || l.closed {
+		return nil
+	}
+	l.stop()
+	return l.start()
+}
+
+// Stops following the output, called on unmount.
+func (l *containerLogs) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.closed = true
+	l.stop()
+	for _, buffer := range []*logBuffer{l.stdout, l.stderr} {
+		buffer.close()
+	}
+}
+
+// Must be called with mutex held.
+func (l *containerLogs) start() error {
+	info, err := l.mng.docker.ContainerInspect(false)
+	if err != nil {
+		return err
+	}
+	stdout, err := l.stdout.reset(l.mng.containerId())
+	if err != nil {
+		return err
+	}
+	stderr, err := l.stderr.reset(l.mng.containerId())
+	if err != nil {
+		return err
+	}
+	reader, err := l.mng.docker.ContainerLogs(true)
+	if err != nil {
+		return err
+	}
+	l.fetched, l.reader = true, reader
+
+	go func() {
+		var err error
+		if info.Config.Tty {
+			_, err = io.Copy(stdout, reader)
+		} else {
+			err = demuxLogs(reader, stdout, stderr)
+		}
+		log.Printf("[info] Following logs of container %v finished: %v", l.mng.containerId(), err)
+
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		// it's not stopped in favor of a newer one
+		if l.reader == reader {
+			reader.Close()
+			l.reader = nil
+		}
+	}()
+	return nil
+}
+
+// Must be called with mutex held.
+func (l *containerLogs) stop() {
+	if l.reader != nil {
+		l.reader.Close()
+		l.reader = nil
+	}
+}
+
+// Splits multiplexed container output: every frame has 8-byte header
+// with stream type in the first byte and big-endian frame size in the last 4 bytes.
+func demuxLogs(reader io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(reader, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var output io.Writer
+		switch header[0] {
+		case streamStdout:
+			output = stdout
+		case streamStderr:
+			output = stderr
+		default:
+			return fmt.Errorf("Unexpected stream type in container output: %d", header[0])
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(output, reader, size); err != nil {
+			return err
+		}
+	}
+}
+
+// logBuffer is an append-only file.
+type logBuffer struct {
+	file  *os.File
+	size  int64
+	mutex sync.RWMutex
+}
+
+// Starts the buffer from scratch, output is appended by the returned writer
+// until the buffer is reset again or closed.
+func (b *logBuffer) reset(id string) (io.Writer, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.file != nil {
+		b.file.Close()
+	}
+	file, err := cacheFile(fmt.Sprintf("logs_%s_", id))
+	if err != nil {
+		return nil, err
+	}
+	b.file, b.size = file, 0
+	return &logWriter{buffer: b, file: file}, nil
+}
+
+func (b *logBuffer) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.file != nil {
+		b.file.Close()
+		b.file, b.size = nil, 0
+	}
+}
+
+// logWriter appends to the buffer while it keeps the same file.
+type logWriter struct {
+	buffer *logBuffer
+	file   *os.File
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	b := w.buffer
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.file != w.file {
+		return 0, os.ErrClosed
+	}
+	n, err := b.file.WriteAt(p, b.size)
+	b.size += int64(n)
+	return n, err
+}
+
+func (b *logBuffer) ReadAt(p []byte, off int64) (int, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	if b.file == nil || off >= b.size {
+		return 0, io.EOF
+	}
+	if rest := b.size - off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	return b.file.ReadAt(p, off)
+}
+
+func (b *logBuffer) Size() int64 {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.size
+}
+
+// LogFile is a virtual read-only file growing as container writes its output.
+type LogFile struct {
+	fs.Inode
+	mng *Mng
+
+	fullpath string
+	logs     *containerLogs
+	buffer   *logBuffer
+}
+
+func (f *LogFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, syserr syscall.Errno) {
+	defer log.Printf("[debug] LogFile (%s) Open(%o): %v", f.fullpath, flags, syserr)
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EACCES
+	}
+	if err := f.logs.follow(); err != nil {
+		log.Printf("[error] Failed to follow container logs: %v", err)
+		return nil, 0, syscall.EIO
+	}
+	// file grows independently from page cache
+	return nil, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (f *LogFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := f.buffer.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		log.Printf("[error] Failed to read %q: %v", f.fullpath, err)
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (f *LogFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Owner.Uid, out.Owner.Gid = f.mng.opts.Uid, f.mng.opts.Gid
+	out.Mode = 0444
+	out.Nlink = 1
+	out.Size = uint64(f.buffer.Size())
+	return 0
+}
@@ -7,23 +7,35 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/plesk/docker-fs/lib/log"
 
 	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
 )
 
 type Mng struct {
-	dockerAddr string
-	docker     dockerMng
+	opts Options
+	// nil if FS is not served from docker (e.g. an image layer)
+	docker DockerMng
+	// files of the FS, it's docker one for containers
+	content ContentReader
 
-	id string
+	// ID of the container, it changes when container is recreated, guarded by idMutex
+	id      string
+	idMutex sync.Mutex
 
 	inodes *Ino
 
-	staticFiles map[string]os.FileMode
+	// advisory file locks, local to the mount
+	locks *Locks
+
+	// container content fetched on mount (or on reconnect), guarded by contentMutex
+	staticFiles  map[string]staticFile
+	contentMutex sync.RWMutex
 
 	changes               FsChanges
 	changesUpdated        time.Time
@@ -31,46 +43,177 @@ type Mng struct {
 	// TODO replace with RWMutex
 	changesMutex sync.Mutex
 
-	// current user uid, gid
-	uid, gid uint32
+	// cached FS usage
+	statfs               *fuse.StatfsOut
+	statfsUpdated        time.Time
+	statfsUpdateInterval time.Duration
+	statfsMutex          sync.Mutex
+
+	// cached extended attributes, at most xattrsCacheSize paths
+	xattrs          map[string]*xattrsEntry
+	xattrsMutex     sync.Mutex
+	xattrsCacheSize int
+
+	// container bind mounts and volumes and their content by destination, guarded by contentMutex
+	mounts        []MountPoint
+	mountsContent map[string]*mountContent
+
+	// FS is mounted as a subtree (e.g. an image layer or one of several containers):
+	// inode keys are prefixed with its path
+	inodePrefix string
+	// there is no container behind the FS (e.g. an image layer), so control directory is not served
+	noControlDir bool
+
+	// files opened for writing, their content is saved to container on close
+	writtenFiles map[*File]bool
+	filesMutex   sync.Mutex
+
+	root *Dir
+	// container is followed across restarts and recreation
+	reconnect *reconnectTarget
+	events    *EventStream
+
+	logs *containerLogs
 }
 
-func NewMng(containerId string) *Mng {
-	return &Mng{
+func NewMng(containerId string, opts Options) *Mng {
+	m := &Mng{
 		id:                    containerId,
-		dockerAddr:            "unix:/var/run/docker.sock",
+		opts:                  opts,
 		changesUpdateInterval: 1 * time.Second,
+		statfsUpdateInterval:  10 * time.Second,
 		inodes:                NewIno(),
-		uid:                   uint32(os.Getuid()),
-		gid:                   uint32(os.Getgid()),
+		locks:                 NewLocks(),
+		xattrs:                make(map[string]*xattrsEntry),
+		xattrsCacheSize:       defaultXattrsCacheSize,
+		writtenFiles:          make(map[*File]bool),
 	}
+	m.logs = newContainerLogs(m)
+	return m
+}
+
+// Close stops following the container and its output, called once FS is unmounted.
+func (m *Mng) Close() {
+	m.disableReconnect()
+	m.logs.close()
+}
+
+// Returns ID of the container currently served.
+func (m *Mng) containerId() string {
+	m.idMutex.Lock()
+	defer m.idMutex.Unlock()
+	return m.id
+}
+
+// Switches to the container recreated with a new ID.
+func (m *Mng) setContainerId(id string) {
+	m.idMutex.Lock()
+	defer m.idMutex.Unlock()
+	m.id = id
+	m.docker.SetContainerId(id)
 }
 
 func (m *Mng) Init() (err error) {
 	if m.docker == nil {
-		httpc, err := NewClient(m.dockerAddr)
+		httpc, err := NewClient(m.opts.DockerAddr)
 		if err != nil {
 			return err
 		}
 		m.docker = NewDockerMng(httpc, m.id)
 	}
+	m.content = m.docker
+	if _, err = m.load(); err != nil {
+		return err
+	}
+	if m.opts.Reconnect {
+		return m.EnableReconnect()
+	}
+	return nil
+}
+
+// Fetches container content and mounts.
+func (m *Mng) load() (*ContainerInfo, error) {
+	info, err := m.docker.ContainerInspect(false)
+	if err != nil {
+		return nil, err
+	}
 
 	log.Printf("[debug] fetching container content...")
 	archPath, err := m.fetchContainerArchive()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer os.Remove(archPath)
 	log.Printf("[debug] parse container content...")
-	m.staticFiles, err = parseContainterContent(archPath)
-	return err
+	static, err := parseContainterContent(archPath)
+	if err != nil {
+		return nil, err
+	}
+	hideMounted(static, info.Mounts)
+	contents := make(map[string]*mountContent)
+	for _, mnt := range info.Mounts {
+		contents[filepath.Clean(mnt.Destination)] = &mountContent{}
+	}
+
+	m.contentMutex.Lock()
+	m.staticFiles, m.mounts, m.mountsContent = static, info.Mounts, contents
+	m.contentMutex.Unlock()
+	return info, nil
+}
+
+func (m *Mng) containerMounts() []MountPoint {
+	m.contentMutex.RLock()
+	defer m.contentMutex.RUnlock()
+	return m.mounts
+}
+
+// Converts symlink target from container to the one shown through the mount.
+func (m *Mng) mountLinkTarget(target string) string {
+	if m.opts.SymlinkRoot == "" || !filepath.IsAbs(target) {
+		return target
+	}
+	return m.opts.SymlinkRoot + target
+}
+
+// Converts symlink target created through the mount to the one stored in container.
+func (m *Mng) containerLinkTarget(target string) string {
+	if m.opts.SymlinkRoot == "" {
+		return target
+	}
+	if target == m.opts.SymlinkRoot {
+		return "/"
+	}
+	if strings.HasPrefix(target, m.opts.SymlinkRoot+"/") {
+		return target[len(m.opts.SymlinkRoot):]
+	}
+	return target
+}
+
+// Options required to mount the FS returned by Root().
+// Read-only mode is enforced by Mng itself: "ro" mount option can't be used,
+// go-fuse creates a file in the mount point right after mounting.
+func (m *Mng) MountOptions() *fs.Options {
+	options := &fs.Options{
+		MountOptions: fuse.MountOptions{
+			// Forward locks to Mng.locks
+			EnableLocks: true,
+			// FS type is shown as fuse.dockerfs, so mounts are recognized in /proc/self/mountinfo
+			Name: "dockerfs",
+		},
+	}
+	if m.opts.Cache == CacheMetadata {
+		timeout := metadataCacheTimeout
+		options.EntryTimeout, options.AttrTimeout = &timeout, &timeout
+	}
+	return options
 }
 
 func (m *Mng) Root() fs.InodeEmbedder {
-	return &Dir{
+	m.root = &Dir{
 		mng:      m,
 		fullpath: "/",
 	}
+	return m.root
 }
 
 // Fetch container archive and return path to tar-file.
@@ -81,7 +224,7 @@ func (m *Mng) fetchContainerArchive() (path string, err error) {
 	}
 	defer respBody.Close()
 
-	output, err := prepareOutputFile(m.id)
+	output, err := prepareOutputFile(m.containerId())
 	defer output.Close()
 
 	if err != nil {
@@ -106,7 +249,7 @@ func prepareOutputFile(id string) (*os.File, error) {
 	return file, err
 }
 
-func parseContainterContent(file string) (map[string]os.FileMode, error) {
+func parseContainterContent(file string) (map[string]staticFile, error) {
 	f, err := os.Open(file)
 	if err != nil {
 		return nil, err
@@ -114,7 +257,7 @@ func parseContainterContent(file string) (map[string]os.FileMode, error) {
 	defer f.Close()
 	tr := tar.NewReader(f)
 
-	result := make(map[string]os.FileMode)
+	result := make(map[string]staticFile)
 	for {
 		hdr, err := tr.Next()
 		if err == io.EOF {
@@ -128,8 +271,18 @@ func parseContainterContent(file string) (map[string]os.FileMode, error) {
 		}
 
 		switch hdr.Typeflag {
-		case tar.TypeReg, tar.TypeRegA, tar.TypeSymlink:
-			result["/"+filepath.Clean(hdr.Name)] = os.FileMode(uint32(hdr.Mode))
+		case tar.TypeReg, tar.TypeRegA, tar.TypeFifo:
+			result["/"+filepath.Clean(hdr.Name)] = staticFile{mode: hdr.FileInfo().Mode()}
+		case tar.TypeSymlink:
+			result["/"+filepath.Clean(hdr.Name)] = staticFile{
+				mode: hdr.FileInfo().Mode(),
+				link: hdr.Linkname,
+			}
+		case tar.TypeChar, tar.TypeBlock:
+			result["/"+filepath.Clean(hdr.Name)] = staticFile{
+				mode: hdr.FileInfo().Mode(),
+				rdev: mkdev(hdr.Devmajor, hdr.Devminor),
+			}
 		case tar.TypeDir:
 			// skip empty dirs
 		default:
@@ -139,20 +292,29 @@ func parseContainterContent(file string) (map[string]os.FileMode, error) {
 	return result, nil
 }
 
-func (m *Mng) ChangesInDir(dir string) (result FsChanges, err error) {
+// Returns container FS changes, they are refreshed if cached ones are outdated.
+func (m *Mng) FsChanges() (FsChanges, error) {
 	m.changesMutex.Lock()
 	defer m.changesMutex.Unlock()
 	if m.changes == nil || time.Now().After(m.changesUpdated.Add(m.changesUpdateInterval)) {
-		changes, err := m.docker.GetFsChanges()
+		changes, err := m.content.GetFsChanges()
 		if err != nil {
 			return nil, err
 		}
 		m.changes = changes
 		m.changesUpdated = time.Now()
 	}
+	return m.changes, nil
+}
+
+func (m *Mng) ChangesInDir(dir string) (result FsChanges, err error) {
+	changes, err := m.FsChanges()
+	if err != nil {
+		return nil, err
+	}
 
 	dir = filepath.Clean(dir)
-	for _, change := range m.changes {
+	for _, change := range changes {
 		// let's skip modified files for now
 		if change.Kind == FileModified {
 			continue
@@ -161,7 +323,7 @@ func (m *Mng) ChangesInDir(dir string) (result FsChanges, err error) {
 			// Not a direct child
 			continue
 		}
-		stat, err := m.docker.GetPathAttrs(change.Path)
+		stat, err := m.content.GetPathAttrs(change.Path)
 		if err != nil {
 			if !errors.As(err, &ErrorNotFound{}) {
 				log.Printf("[error] Failed to get raw attrs of %q: %v", change.Path, err)
@@ -0,0 +1,264 @@
+package dockerfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/plesk/docker-fs/lib/log"
+)
+
+// Bind mounts and volumes are not included into container export and are not reported by /changes,
+// so their content is fetched separately on first access, see mountFiles.
+// Container content under mount points is hidden by the mounts, while mount points are shown even if they're empty.
+func hideMounted(static map[string]staticFile, mounts []MountPoint) {
+	for _, mnt := range mounts {
+		dest := filepath.Clean(mnt.Destination)
+		for path := range static {
+			if isSubPath(path, dest) {
+				delete(static, path)
+			}
+		}
+		static[dest] = staticFile{mode: os.ModeDir | 0755}
+	}
+}
+
+// Content of a bind mount or a volume, nil until it's fetched.
+type mountContent struct {
+	mutex sync.Mutex
+	files map[string]staticFile
+	// files are read from the host path, see hostPath
+	host bool
+}
+
+// Returns content fetched on mount which the path belongs to: content of the innermost
+// bind mount or volume containing the path, container content otherwise. The map must not be modified.
+func (m *Mng) contentOf(path string) map[string]staticFile {
+	m.contentMutex.RLock()
+	static, mounts, contents := m.staticFiles, m.mounts, m.mountsContent
+	m.contentMutex.RUnlock()
+	mnt := innermostMount(mounts, path)
+	if mnt == nil {
+		return static
+	}
+	content := contents[filepath.Clean(mnt.Destination)]
+	if content == nil {
+		// mounts were changed meanwhile
+		content = &mountContent{}
+	}
+	return m.mountFiles(mnt, mounts, content)
+}
+
+// Returns file of content fetched on mount, see contentOf.
+func (m *Mng) staticFile(path string) (staticFile, bool) {
+	if mnt := m.mountOf(path); mnt != nil && filepath.Clean(mnt.Destination) == path {
+		// mount point is resolved on every access below it, content of the mount isn't needed for that
+		return staticFile{mode: os.ModeDir | 0755}, true
+	}
+	file, ok := m.contentOf(path)[path]
+	return file, ok
+}
+
+// Returns content of the mount, it's fetched once: straight from the host path if it's accessible locally
+// (bind mounts, volumes if docker-fs is run by root), through archive API otherwise.
+func (m *Mng) mountFiles(mnt *MountPoint, mounts []MountPoint, content *mountContent) map[string]staticFile {
+	content.mutex.Lock()
+	defer content.mutex.Unlock()
+	if content.files != nil {
+		return content.files
+	}
+	dest := filepath.Clean(mnt.Destination)
+	files, err := m.hostContent(mnt)
+	host := err == nil
+	if err != nil {
+		log.Printf("[debug] Host path %q of %v is not accessible (%v), fetching it through docker API...", mnt.Source, dest, err)
+		files, err = m.archiveContent(dest)
+	}
+	if err != nil {
+		// fetching is retried on the next access
+		log.Printf("[warning] Failed to fetch content of %v mounted to %v: %v", mnt.Type, dest, err)
+		return map[string]staticFile{dest: {mode: os.ModeDir | 0755}}
+	}
+	files[dest] = staticFile{mode: os.ModeDir | 0755}
+	// nested mounts hide content of the mount like the mount hides container content
+	var nested []MountPoint
+	for _, other := range mounts {
+		if filepath.Clean(other.Destination) != dest && isSubPath(other.Destination, dest) {
+			nested = append(nested, other)
+		}
+	}
+	hideMounted(files, nested)
+	content.files, content.host = files, host
+	return files
+}
+
+// Returns host path of the file if content of its mount is read from the host, see mountFiles.
+// Mount points themselves are container directories.
+func (m *Mng) hostPath(path string) (string, bool) {
+	m.contentMutex.RLock()
+	mounts, contents := m.mounts, m.mountsContent
+	m.contentMutex.RUnlock()
+	mnt := innermostMount(mounts, path)
+	if mnt == nil || filepath.Clean(mnt.Destination) == path {
+		return "", false
+	}
+	content := contents[filepath.Clean(mnt.Destination)]
+	if content == nil {
+		return "", false
+	}
+	m.mountFiles(mnt, mounts, content)
+	content.mutex.Lock()
+	host := content.host
+	content.mutex.Unlock()
+	if !host {
+		return "", false
+	}
+	rel, err := filepath.Rel(mnt.Destination, path)
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(mnt.Source, rel), true
+}
+
+// Returns attributes of the file, the ones of mounts read from the host are taken from the host path.
+func (m *Mng) pathAttrs(path string) (*ContainerPathStat, error) {
+	hostPath, ok := m.hostPath(path)
+	if !ok {
+		return m.content.GetPathAttrs(path)
+	}
+	fi, err := os.Lstat(hostPath)
+	if os.IsNotExist(err) {
+		return nil, ErrorNotFound{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	stat := &ContainerPathStat{Name: fi.Name(), Size: fi.Size(), Mode: fi.Mode(), Mtime: fi.ModTime()}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if stat.LinkTarget, err = os.Readlink(hostPath); err != nil {
+			return nil, err
+		}
+	}
+	return stat, nil
+}
+
+// Returns content of the file, see pathAttrs.
+func (m *Mng) fileContent(path string) (io.ReadCloser, error) {
+	hostPath, ok := m.hostPath(path)
+	if !ok {
+		return m.content.GetFile(path)
+	}
+	file, err := os.Open(hostPath)
+	if os.IsNotExist(err) {
+		return nil, ErrorNotFound{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Returns content of the host path mounted to the container. Host paths are meaningful
+// only if docker engine runs on the same host.
+func (m *Mng) hostContent(mnt *MountPoint) (map[string]staticFile, error) {
+	if !strings.HasPrefix(m.opts.DockerAddr, "unix:") {
+		return nil, fmt.Errorf("Docker engine is not local")
+	}
+	if mnt.Source == "" {
+		return nil, fmt.Errorf("Mount source is unknown")
+	}
+	result := make(map[string]staticFile)
+	err := filepath.Walk(mnt.Source, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			// directories are derived from file paths like for container content
+			return nil
+		}
+		rel, err := filepath.Rel(mnt.Source, file)
+		if err != nil {
+			return err
+		}
+		static := staticFile{mode: fi.Mode()}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if static.link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && fi.Mode()&os.ModeDevice != 0 {
+			static.rdev = uint32(st.Rdev)
+		}
+		result[filepath.Join(mnt.Destination, rel)] = static
+		return nil
+	})
+	return result, err
+}
+
+// Returns content of the container directory fetched through archive API.
+func (m *Mng) archiveContent(dir string) (map[string]staticFile, error) {
+	reader, err := m.docker.GetArchive(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	// archive entries are relative to the parent directory: /var/lib/mysql -> mysql/...
+	parent := filepath.Dir(dir)
+	result := make(map[string]staticFile)
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(parent, hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeFifo:
+			result[path] = staticFile{mode: hdr.FileInfo().Mode()}
+		case tar.TypeSymlink:
+			result[path] = staticFile{mode: hdr.FileInfo().Mode(), link: hdr.Linkname}
+		case tar.TypeChar, tar.TypeBlock:
+			result[path] = staticFile{mode: hdr.FileInfo().Mode(), rdev: mkdev(hdr.Devmajor, hdr.Devminor)}
+		}
+	}
+}
+
+// Returns the mount containing the path (the innermost one if mounts are nested).
+func (m *Mng) mountOf(path string) *MountPoint {
+	return innermostMount(m.containerMounts(), path)
+}
+
+func innermostMount(mounts []MountPoint, path string) *MountPoint {
+	var result *MountPoint
+	for i := range mounts {
+		mnt := &mounts[i]
+		if !isSubPath(path, mnt.Destination) {
+			continue
+		}
+		if result == nil || len(mnt.Destination) > len(result.Destination) {
+			result = mnt
+		}
+	}
+	return result
+}
+
+// Value of XattrMount: type, source and mode of the mount, e.g. "bind /home/user/app rw".
+func mountXattr(mnt *MountPoint) string {
+	source := mnt.Source
+	if mnt.Type == "volume" && mnt.Name != "" {
+		source = mnt.Name
+	}
+	mode := "ro"
+	if mnt.RW {
+		mode = "rw"
+	}
+	return strings.Join([]string{mnt.Type, source, mode}, " ")
+}
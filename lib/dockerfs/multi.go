@@ -0,0 +1,343 @@
+package dockerfs
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/plesk/docker-fs/lib/log"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+var _ = (fs.NodeGetattrer)((*ContainersDir)(nil))
+var _ = (fs.NodeLookuper)((*ContainersDir)(nil))
+var _ = (fs.NodeReaddirer)((*ContainersDir)(nil))
+
+// ContainerSelector decides if the container is mounted and returns name of its directory.
+// Labels are the container labels (or attributes of the container event, which include them).
+type ContainerSelector func(name string, labels map[string]string) (dir string, ok bool)
+
+// SelectContainers selects containers by name, all containers are selected if names are empty.
+// Directories are named after containers.
+func SelectContainers(names []string) ContainerSelector {
+	selected := make(map[string]bool)
+	for _, name := range names {
+		selected[strings.TrimPrefix(name, "/")] = true
+	}
+	return func(name string, labels map[string]string) (string, bool) {
+		return name, len(selected) == 0 || selected[name]
+	}
+}
+
+// SelectComposeProject selects containers of docker compose project, directories are named after services.
+// Replicas of a scaled service are named <service>-<n>, the first one is named after the service.
+func SelectComposeProject(project string) ContainerSelector {
+	return func(name string, labels map[string]string) (string, bool) {
+		service := labels[composeServiceLabel]
+		if labels[composeProjectLabel] != project || service == "" {
+			return "", false
+		}
+		if n := labels[composeNumberLabel]; n != "" && n != "1" {
+			return service + "-" + n, true
+		}
+		return service, true
+	}
+}
+
+// MultiMng serves several containers under one root: /<container-name>/...
+// Every container is served by its own Mng, they share HTTP client, inode numbers generator
+// and subscription to docker events. Containers appear on start and disappear on stop.
+type MultiMng struct {
+	// options of the FS and default options of containers, symlinks are rewritten
+	// to point inside container directory
+	opts Options
+	// returns options of the container, if it's set
+	optionsOf func(docker DockerMng) (Options, error)
+	// not bound to any container, used to list containers and follow events
+	docker DockerMng
+	events *EventStream
+	// returns docker API manager of the container
+	newDocker func(id string) DockerMng
+
+	selector ContainerSelector
+
+	inodes *Ino
+	// containers ever mounted by directory name, guarded by mutex
+	containers map[string]*multiContainer
+	mutex      sync.Mutex
+
+	root *ContainersDir
+}
+
+type multiContainer struct {
+	mng  *Mng
+	root *Dir
+	// ID of the container currently served in the directory
+	id      string
+	running bool
+}
+
+// NewMultiMng creates manager of containers chosen by the selector, they are not followed
+// by Reconnect option: containers are shown on start and hidden on stop anyway.
+// Options of every container are returned by optionsOf, all containers share opts if it's nil.
+// Options of the FS itself (cache mode) are always taken from opts.
+func NewMultiMng(selector ContainerSelector, opts Options, optionsOf func(docker DockerMng) (Options, error)) *MultiMng {
+	opts.Reconnect = false
+	return &MultiMng{
+		opts:       opts,
+		optionsOf:  optionsOf,
+		selector:   selector,
+		inodes:     NewIno(),
+		containers: make(map[string]*multiContainer),
+	}
+}
+
+// Init fetches content of running selected containers and starts following docker events.
+// Containers which content can't be fetched are skipped, they are shown once they are started again.
+func (m *MultiMng) Init() error {
+	if m.docker == nil {
+		httpc, err := NewClient(m.opts.DockerAddr)
+		if err != nil {
+			return err
+		}
+		m.docker = NewDockerMng(httpc, "")
+		m.newDocker = func(id string) DockerMng {
+			return NewDockerMng(httpc, id)
+		}
+	}
+	// containers started while the others are listed and fetched are not missed
+	events, err := SubscribeEvents(m.docker)
+	if err != nil {
+		return err
+	}
+	list, err := m.docker.ContainersList()
+	if err != nil {
+		events.Close()
+		return err
+	}
+	for _, c := range list {
+		if c.State != "running" || len(c.Names) == 0 {
+			continue
+		}
+		dir, ok := m.selector(strings.TrimPrefix(c.Names[0], "/"), c.Labels)
+		if !ok {
+			continue
+		}
+		if err := m.start(dir, c.Id); err != nil {
+			log.Printf("[error] Failed to mount container %v: %v", c.Id, err)
+		}
+	}
+	m.events = events
+	go events.Follow(m.handleEvent)
+	return nil
+}
+
+func (m *MultiMng) handleEvent(event *ContainerEvent) {
+	switch event.Action {
+	case "start":
+		dir, ok := m.selector(strings.TrimPrefix(event.Actor.Attributes["name"], "/"), event.Actor.Attributes)
+		if !ok {
+			return
+		}
+		log.Printf("[info] Container %v is started, mounting it to %v...", event.Actor.ID, dir)
+		if err := m.start(dir, event.Actor.ID); err != nil {
+			log.Printf("[error] Failed to mount container %v: %v", event.Actor.ID, err)
+		}
+	case "die":
+		m.stop(event.Actor.ID)
+	}
+}
+
+// Shows the container in the directory. Mng of the directory is reused if the container
+// was shown there before, otherwise kernel would get the stale one by the same inode number.
+func (m *MultiMng) start(dir, id string) error {
+	m.mutex.Lock()
+	c, ok := m.containers[dir]
+	// listed on Init and its start event is received afterwards
+	started := ok && c.running && c.id == id
+	m.mutex.Unlock()
+
+	if started {
+		return nil
+	}
+	if ok {
+		if err := c.mng.containerStarted(id); err != nil {
+			return err
+		}
+	} else {
+		docker := m.newDocker(id)
+		opts := m.opts
+		if m.optionsOf != nil {
+			var err error
+			if opts, err = m.optionsOf(docker); err != nil {
+				return err
+			}
+			opts.Reconnect, opts.Cache = false, m.opts.Cache
+		}
+		if opts.SymlinkRoot != "" {
+			opts.SymlinkRoot = filepath.Join(opts.SymlinkRoot, dir)
+		}
+		mng := NewMng(id, opts)
+		mng.docker = docker
+		mng.inodes = m.inodes
+		mng.inodePrefix = "/" + dir
+		if err := mng.Init(); err != nil {
+			return err
+		}
+		c = &multiContainer{mng: mng, root: mng.Root().(*Dir)}
+	}
+
+	m.mutex.Lock()
+	c.id, c.running = id, true
+	m.containers[dir] = c
+	m.mutex.Unlock()
+	m.notifyEntry(dir)
+	return nil
+}
+
+// Hides directory of the stopped container.
+func (m *MultiMng) stop(id string) {
+	m.mutex.Lock()
+	var stopped []string
+	for dir, c := range m.containers {
+		if c.running && c.id == id {
+			c.running = false
+			stopped = append(stopped, dir)
+		}
+	}
+	m.mutex.Unlock()
+	for _, dir := range stopped {
+		log.Printf("[info] Container %v is stopped, hiding %v...", id, dir)
+		m.notifyEntry(dir)
+	}
+}
+
+func (m *MultiMng) notifyEntry(dir string) {
+	if m.root != nil {
+		// errors mean that kernel doesn't know the entry, so there is nothing to invalidate
+		_ = m.root.NotifyEntry(dir)
+	}
+}
+
+// Returns the container shown in the directory.
+func (m *MultiMng) running(dir string) (*multiContainer, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	c, ok := m.containers[dir]
+	if !ok || !c.running {
+		return nil, false
+	}
+	return c, true
+}
+
+// Close stops following containers, called once FS is unmounted.
+func (m *MultiMng) Close() {
+	if m.events != nil {
+		m.events.Close()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, c := range m.containers {
+		c.mng.Close()
+	}
+}
+
+// FlushFiles saves content of files opened for writing in all containers.
+func (m *MultiMng) FlushFiles() error {
+	m.mutex.Lock()
+	var mngs []*Mng
+	for _, c := range m.containers {
+		mngs = append(mngs, c.mng)
+	}
+	m.mutex.Unlock()
+
+	var result error
+	for _, mng := range mngs {
+		if err := mng.FlushFiles(); err != nil {
+			log.Printf("[error] Container %v: %v", mng.containerId(), err)
+			result = err
+		}
+	}
+	return result
+}
+
+// Refresh fetches content of all running containers again and drops everything cached.
+func (m *MultiMng) Refresh() error {
+	m.mutex.Lock()
+	var mngs []*Mng
+	for _, c := range m.containers {
+		if c.running {
+			mngs = append(mngs, c.mng)
+		}
+	}
+	m.mutex.Unlock()
+
+	var result error
+	for _, mng := range mngs {
+		if err := mng.Refresh(); err != nil {
+			log.Printf("[error] Failed to reload content of container %v: %v", mng.containerId(), err)
+			result = err
+		}
+	}
+	return result
+}
+
+// Options required to mount the FS returned by Root(), they are the same as for a single container.
+func (m *MultiMng) MountOptions() *fs.Options {
+	return NewMng("", m.opts).MountOptions()
+}
+
+func (m *MultiMng) Root() fs.InodeEmbedder {
+	m.root = &ContainersDir{mng: m}
+	return m.root
+}
+
+// ContainersDir is the mount root with directories of running containers.
+type ContainersDir struct {
+	fs.Inode
+	mng *MultiMng
+}
+
+func (d *ContainersDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Owner.Uid, out.Owner.Gid = d.mng.opts.Uid, d.mng.opts.Gid
+	out.Mode = 0755
+	return 0
+}
+
+func (d *ContainersDir) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (n *fs.Inode, syserr syscall.Errno) {
+	defer log.Printf("[debug] ContainersDir Lookup(%s): %v", name, syserr)
+	c, ok := d.mng.running(name)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	out.Owner.Uid, out.Owner.Gid = d.mng.opts.Uid, d.mng.opts.Gid
+	ino := d.mng.inodes.Inode(filepath.Join("/", name))
+	return d.NewPersistentInode(ctx, c.root, fs.StableAttr{Mode: fuse.S_IFDIR, Ino: ino}), 0
+}
+
+func (d *ContainersDir) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	d.mng.mutex.Lock()
+	var names []string
+	for dir, c := range d.mng.containers {
+		if c.running {
+			names = append(names, dir)
+		}
+	}
+	d.mng.mutex.Unlock()
+	sort.Strings(names)
+
+	var list []fuse.DirEntry
+	for _, name := range names {
+		list = append(list, fuse.DirEntry{
+			Mode: fuse.S_IFDIR,
+			Name: name,
+			Ino:  d.mng.inodes.Inode(filepath.Join("/", name)),
+		})
+	}
+	return fs.NewListDirStream(list), 0
+}
@@ -0,0 +1,58 @@
+package dockerfs
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// CacheMode defines what kernel caches between FS calls.
+type CacheMode string
+
+const (
+	// Nothing is cached, changes made inside container show up right away
+	CacheNone CacheMode = "none"
+	// Attributes and directory entries are cached for metadataCacheTimeout,
+	// listing of big trees is faster, while changes inside container show up with a delay
+	CacheMetadata CacheMode = "metadata"
+)
+
+// How long kernel caches attributes and directory entries with CacheMetadata
+const metadataCacheTimeout = 1 * time.Second
+
+func ParseCacheMode(mode string) (CacheMode, error) {
+	switch CacheMode(mode) {
+	case CacheNone, CacheMetadata:
+		return CacheMode(mode), nil
+	}
+	return "", fmt.Errorf("Unknown cache mode %q, expected %v or %v", mode, CacheNone, CacheMetadata)
+}
+
+// Options of the FS served by Mng (or MultiMng).
+type Options struct {
+	// docker engine socket, e.g. unix:/var/run/docker.sock (see NewClient)
+	DockerAddr string
+	// owner of all files
+	Uid, Gid uint32
+	Cache    CacheMode
+	// absolute mount point to prefix absolute symlink targets with (empty if rewriting is disabled)
+	SymlinkRoot string
+	// allow writes to host paths bind-mounted into container
+	AllowBindWrites bool
+	// show diff view in control directory
+	DiffView bool
+	// reject all modifications (e.g. for mounted images)
+	ReadOnly bool
+	// follow container across restarts and recreation, see EnableReconnect
+	Reconnect bool
+}
+
+// DefaultOptions returns options of the FS owned by the current user and served from local docker.
+func DefaultOptions() Options {
+	return Options{
+		DockerAddr: "unix:/var/run/docker.sock",
+		Uid:        uint32(os.Getuid()),
+		Gid:        uint32(os.Getgid()),
+		Cache:      CacheNone,
+	}
+}
@@ -0,0 +1,221 @@
+package dockerfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plesk/docker-fs/lib/log"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// Labels docker compose sets on service containers
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+	// replica number of the service container, starting from 1
+	composeNumberLabel = "com.docker.compose.container-number"
+)
+
+// Delay before following events again if the stream was closed (e.g. docker daemon was restarted)
+const eventsRetryInterval = 5 * time.Second
+
+// reconnectTarget identifies container regardless of its ID:
+// by compose project and service if it's a compose service, by name otherwise.
+type reconnectTarget struct {
+	name             string
+	project, service string
+}
+
+func (t *reconnectTarget) String() string {
+	if t.service != "" {
+		return fmt.Sprintf("compose service %s/%s", t.project, t.service)
+	}
+	return "container " + t.name
+}
+
+func (t *reconnectTarget) matches(event *ContainerEvent) bool {
+	attrs := event.Actor.Attributes
+	if t.service != "" {
+		return attrs[composeProjectLabel] == t.project && attrs[composeServiceLabel] == t.service
+	}
+	return strings.TrimPrefix(attrs["name"], "/") == t.name
+}
+
+// EnableReconnect keeps the FS working when container is restarted or recreated with a new ID
+// (e.g. by `docker compose up --force-recreate`): content is fetched again on every start of the container
+// and kernel caches are invalidated. Must be called after Init.
+func (m *Mng) EnableReconnect() error {
+	info, err := m.docker.ContainerInspect(false)
+	if err != nil {
+		return err
+	}
+	target := &reconnectTarget{
+		name:    strings.TrimPrefix(info.Name, "/"),
+		project: info.Config.Labels[composeProjectLabel],
+		service: info.Config.Labels[composeServiceLabel],
+	}
+	events, err := SubscribeEvents(m.docker)
+	if err != nil {
+		return err
+	}
+	m.reconnect, m.events = target, events
+	log.Printf("[info] Following %v", target)
+	go events.Follow(m.handleEvent)
+	return nil
+}
+
+// Stops following the container.
+func (m *Mng) disableReconnect() {
+	if m.events != nil {
+		m.events.Close()
+	}
+}
+
+func (m *Mng) handleEvent(event *ContainerEvent) {
+	if event.Action != "start" || !m.reconnect.matches(event) {
+		return
+	}
+	log.Printf("[info] Container %v of %v is started, reloading content...", event.Actor.ID, m.reconnect)
+	if err := m.containerStarted(event.Actor.ID); err != nil {
+		log.Printf("[error] Failed to reload content of container %v: %v", event.Actor.ID, err)
+	}
+}
+
+// Serves the container started with the ID: it's restarted or recreated with a new ID.
+func (m *Mng) containerStarted(id string) error {
+	m.setContainerId(id)
+	return m.Refresh()
+}
+
+// EventStream is a subscription to container events.
+type EventStream struct {
+	docker DockerMng
+	// reopened if the stream is closed by docker daemon, guarded by mutex
+	reader io.ReadCloser
+	closed chan struct{}
+	mutex  sync.Mutex
+}
+
+// SubscribeEvents starts receiving container events, they are passed to the handler by Follow.
+// Events which happen meanwhile are not lost, so containers can be listed after subscribing
+// without missing the ones started (or stopped) in between.
+func SubscribeEvents(docker DockerMng) (*EventStream, error) {
+	reader, err := docker.Events()
+	if err != nil {
+		return nil, err
+	}
+	return &EventStream{docker: docker, reader: reader, closed: make(chan struct{})}, nil
+}
+
+// Follow passes container events to the handler until the stream is closed by Close.
+// Events are followed again if the stream is closed by docker daemon (e.g. it was restarted).
+func (s *EventStream) Follow(handle func(event *ContainerEvent)) {
+	s.mutex.Lock()
+	reader := s.reader
+	s.mutex.Unlock()
+	for {
+		err := readEvents(reader, handle)
+		reader.Close()
+		for {
+			select {
+			case <-s.closed:
+				return
+			default:
+			}
+			log.Printf("[warning] Following docker events failed: %v", err)
+			select {
+			case <-s.closed:
+				return
+			case <-time.After(eventsRetryInterval):
+			}
+			if reader, err = s.docker.Events(); err == nil {
+				break
+			}
+		}
+		s.mutex.Lock()
+		s.reader = reader
+		s.mutex.Unlock()
+		// closed while the stream was reopened
+		select {
+		case <-s.closed:
+			reader.Close()
+			return
+		default:
+		}
+	}
+}
+
+// Close stops following events, Follow returns.
+func (s *EventStream) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.closed:
+		return
+	default:
+	}
+	close(s.closed)
+	s.reader.Close()
+}
+
+func readEvents(reader io.Reader, handle func(event *ContainerEvent)) error {
+	decoder := json.NewDecoder(reader)
+	for {
+		var event ContainerEvent
+		if err := decoder.Decode(&event); err != nil {
+			return err
+		}
+		handle(&event)
+	}
+}
+
+// Refresh fetches container content and output again and drops everything cached, e.g. after files
+// are changed not through the mount or container is restarted.
+func (m *Mng) Refresh() error {
+	if err := m.reload(); err != nil {
+		return err
+	}
+	if err := m.logs.restart(); err != nil {
+		log.Printf("[warning] Failed to follow logs of container %v: %v", m.containerId(), err)
+	}
+	return nil
+}
+
+// Fetches container content again and drops everything cached.
+func (m *Mng) reload() error {
+	if _, err := m.load(); err != nil {
+		return err
+	}
+
+	m.changesMutex.Lock()
+	m.changes = nil
+	m.changesMutex.Unlock()
+
+	m.statfsMutex.Lock()
+	m.statfs = nil
+	m.statfsMutex.Unlock()
+
+	m.xattrsMutex.Lock()
+	m.xattrs = make(map[string]*xattrsEntry)
+	m.xattrsMutex.Unlock()
+
+	if m.root != nil {
+		invalidateTree(m.root.EmbeddedInode())
+	}
+	return nil
+}
+
+// Drops kernel caches of attributes, content and directory entries of the subtree.
+func invalidateTree(node *fs.Inode) {
+	// errors mean that kernel doesn't know the entry, so there is nothing to invalidate
+	_ = node.NotifyContent(0, 0)
+	for name, child := range node.Children() {
+		_ = node.NotifyEntry(name)
+		invalidateTree(child)
+	}
+}
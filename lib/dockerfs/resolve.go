@@ -0,0 +1,149 @@
+package dockerfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Same limit as Linux has for a single path resolution
+const maxSymlinks = 40
+
+type ErrorLoop struct {
+}
+
+func (e ErrorLoop) Error() string {
+	return "Too many levels of symbolic links"
+}
+
+type ErrorReadOnly struct {
+}
+
+func (e ErrorReadOnly) Error() string {
+	return "File system is read-only"
+}
+
+type ErrorBindMount struct {
+	Path string
+}
+
+func (e ErrorBindMount) Error() string {
+	return "Path is bind-mounted from docker host: " + e.Path
+}
+
+// ResolvePath resolves symlinks of the path inside container namespace:
+// absolute targets are resolved starting from container root, so resolved path never leaves container FS.
+// Missing path components are left as is.
+func (m *Mng) ResolvePath(path string) (string, error) {
+	resolved := "/"
+	rest := splitPath(path)
+	links := 0
+	for len(rest) > 0 {
+		name := rest[0]
+		rest = rest[1:]
+		if name == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		target, isLink, err := m.readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if !isLink {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", ErrorLoop{}
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(splitPath(target), rest...)
+	}
+	return resolved, nil
+}
+
+// Returns symlink target, isLink is false if path is not a symlink or doesn't exist.
+// Unchanged files are checked against container content fetched on mount,
+// so only changed ones require API calls.
+func (m *Mng) readlink(path string) (target string, isLink bool, err error) {
+	changes, err := m.FsChanges()
+	if err != nil {
+		return "", false, err
+	}
+	if _, changed := changes.Kind(path); !changed {
+		static, ok := m.staticFile(path)
+		return static.link, ok && static.mode&os.ModeSymlink != 0, nil
+	}
+
+	stat, err := m.pathAttrs(path)
+	if errors.As(err, &ErrorNotFound{}) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return stat.LinkTarget, stat.Mode&os.ModeSymlink != 0, nil
+}
+
+// WritablePath resolves path of the file to be written (the file itself may not exist)
+// and checks that writing it doesn't modify bind-mounted host files or read-only mounts.
+func (m *Mng) WritablePath(path string) (string, error) {
+	if m.opts.ReadOnly {
+		return "", ErrorReadOnly{}
+	}
+	dir, name := filepath.Split(filepath.Clean(path))
+	resolved, err := m.ResolvePath(dir)
+	if err != nil {
+		return "", err
+	}
+	resolved = filepath.Join(resolved, name)
+	mnt := m.mountOf(resolved)
+	if mnt == nil {
+		return resolved, nil
+	}
+	if !mnt.RW {
+		return "", ErrorReadOnly{}
+	}
+	if mnt.Type == "bind" && !m.opts.AllowBindWrites {
+		return "", ErrorBindMount{Path: resolved}
+	}
+	return resolved, nil
+}
+
+func splitPath(path string) []string {
+	var result []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" && name != "." {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// Checks if path is equal to dir or located inside it.
+func isSubPath(path, dir string) bool {
+	dir = filepath.Clean(dir)
+	return path == dir || dir == "/" || strings.HasPrefix(path, dir+"/")
+}
+
+// Converts path resolution errors to errno.
+func resolveErrno(err error) syscall.Errno {
+	switch {
+	case errors.As(err, &ErrorLoop{}):
+		return syscall.ELOOP
+	case errors.As(err, &ErrorBindMount{}), errors.As(err, &ErrorReadOnly{}):
+		return syscall.EROFS
+	case errors.As(err, &ErrorNotFound{}):
+		return syscall.ENOENT
+	default:
+		return syscall.EIO
+	}
+}
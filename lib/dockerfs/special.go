@@ -0,0 +1,52 @@
+package dockerfs
+
+import (
+	"context"
+	"errors"
+	"syscall"
+
+	"github.com/plesk/docker-fs/lib/log"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+var _ = (fs.NodeGetattrer)((*Special)(nil))
+var _ = (fs.NodeOpener)((*Special)(nil))
+
+// Special represents FIFOs, sockets, char and block devices.
+// Their content can't be transferred through the archive API,
+// so only attributes are available.
+type Special struct {
+	fs.Inode
+	mng *Mng
+
+	fullpath string
+}
+
+func (s *Special) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (syserr syscall.Errno) {
+	defer log.Printf("[debug] Special (%s) Getattr(): %v", s.fullpath, syserr)
+	attrs, err := s.mng.pathAttrs(s.fullpath)
+	if errors.As(err, &ErrorNotFound{}) {
+		return syscall.ENOENT
+	}
+	if err != nil {
+		log.Printf("[error] Special(%s) Getting raw attrs failed: %v (%T)", s.fullpath, err, err)
+		return syscall.EIO
+	}
+	out.Mode = fuseMode(attrs.Mode)
+	out.Nlink = 1
+	static, _ := s.mng.staticFile(s.fullpath)
+	out.Rdev = static.rdev
+	out.SetTimes(nil, &attrs.Mtime, nil)
+
+	out.Owner.Uid, out.Owner.Gid = s.mng.opts.Uid, s.mng.opts.Gid
+	return 0
+}
+
+// Normally kernel doesn't ask FUSE to open special files,
+// but don't try to download them if it does.
+func (s *Special) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, mode uint32, syserr syscall.Errno) {
+	defer log.Printf("[debug] Special (%s) Open(%o): %v", s.fullpath, flags, syserr)
+	return nil, 0, syscall.ENXIO
+}
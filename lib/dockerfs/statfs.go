@@ -0,0 +1,69 @@
+package dockerfs
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/plesk/docker-fs/lib/log"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+const statfsBlockSize = 4096
+
+// Statfs returns FS usage: used space is the size of the container root FS (or of static content),
+// free space is taken from the docker data root if it's available locally.
+// Result is cached for statfsUpdateInterval.
+func (m *Mng) Statfs() (*fuse.StatfsOut, error) {
+	m.statfsMutex.Lock()
+	defer m.statfsMutex.Unlock()
+	if m.statfs != nil && time.Now().Before(m.statfsUpdated.Add(m.statfsUpdateInterval)) {
+		return m.statfs, nil
+	}
+
+	size, err := m.rootFsSize()
+	if err != nil {
+		return nil, err
+	}
+	out := &fuse.StatfsOut{
+		Bsize:   statfsBlockSize,
+		Frsize:  statfsBlockSize,
+		NameLen: 255,
+	}
+	used := (uint64(size) + statfsBlockSize - 1) / statfsBlockSize
+
+	// Free space is shared by all containers, it's the space left in docker data root.
+	// It's unknown for static content, which is not served from docker.
+	if m.docker != nil {
+		var host syscall.Statfs_t
+		dockerInfo, err := m.docker.Info()
+		if err == nil {
+			err = syscall.Statfs(dockerInfo.DockerRootDir, &host)
+		}
+		if err != nil {
+			log.Printf("[debug] Free space of docker data root is unknown: %v", err)
+		} else {
+			out.Bfree = host.Bfree * uint64(host.Bsize) / statfsBlockSize
+			out.Bavail = host.Bavail * uint64(host.Bsize) / statfsBlockSize
+			out.Files = host.Files
+			out.Ffree = host.Ffree
+		}
+	}
+	out.Blocks = used + out.Bfree
+
+	m.statfs = out
+	m.statfsUpdated = time.Now()
+	return out, nil
+}
+
+// Returns size of the container root FS, static content reports its size itself.
+func (m *Mng) rootFsSize() (int64, error) {
+	if sized, ok := m.content.(interface{ Size() int64 }); ok {
+		return sized.Size(), nil
+	}
+	info, err := m.docker.ContainerInspect(true)
+	if err != nil {
+		return 0, err
+	}
+	return info.SizeRootFs, nil
+}
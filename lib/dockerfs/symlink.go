@@ -0,0 +1,44 @@
+package dockerfs
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/plesk/docker-fs/lib/log"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+var _ = (fs.NodeReadlinker)((*Symlink)(nil))
+var _ = (fs.NodeGetattrer)((*Symlink)(nil))
+var _ = (fs.NodeGetxattrer)((*Symlink)(nil))
+var _ = (fs.NodeListxattrer)((*Symlink)(nil))
+
+type Symlink struct {
+	fs.Inode
+	mng *Mng
+
+	fullpath string
+	target   []byte
+}
+
+func (l *Symlink) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	return l.target, 0
+}
+
+func (l *Symlink) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Owner.Uid, out.Owner.Gid = l.mng.opts.Uid, l.mng.opts.Gid
+	out.Size = uint64(len(l.target))
+	return 0
+}
+
+func (l *Symlink) Getxattr(ctx context.Context, attr string, dest []byte) (n uint32, syserr syscall.Errno) {
+	defer log.Printf("[debug] Symlink (%s) Getxattr(%q): %d, %v", l.fullpath, attr, n, syserr)
+	return l.mng.getxattr(l.fullpath, attr, dest)
+}
+
+func (l *Symlink) Listxattr(ctx context.Context, dest []byte) (n uint32, syserr syscall.Errno) {
+	defer log.Printf("[debug] Symlink (%s) Listxattr(): %d, %v", l.fullpath, n, syserr)
+	return l.mng.listxattr(l.fullpath, dest)
+}
@@ -2,7 +2,10 @@ package dockerfs
 
 import (
 	"os"
+	"syscall"
 	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
 )
 
 // This type is taken from github.com/docker/docker
@@ -13,3 +16,117 @@ type ContainerPathStat struct {
 	Mtime      time.Time   `json:"mtime"`
 	LinkTarget string      `json:"linkTarget"`
 }
+
+// Container details returned by /containers/{id}/json
+type ContainerInfo struct {
+	Id   string `json:"Id"`
+	Name string `json:"Name"`
+	// Image ID
+	Image string `json:"Image"`
+	// Sizes are filled only if requested
+	SizeRw     int64 `json:"SizeRw"`
+	SizeRootFs int64 `json:"SizeRootFs"`
+
+	Mounts []MountPoint    `json:"Mounts"`
+	Config ContainerConfig `json:"Config"`
+	State  ContainerState  `json:"State"`
+}
+
+type ContainerState struct {
+	// created, running, paused, restarting, exited...
+	Status  string `json:"Status"`
+	Running bool   `json:"Running"`
+	// paused container is running as well
+	Paused bool `json:"Paused"`
+}
+
+// Processes can be executed only in running containers which are not paused.
+func (s *ContainerState) canExec() bool {
+	return s.Running && !s.Paused
+}
+
+type ContainerConfig struct {
+	// image name the container is created from
+	Image  string            `json:"Image"`
+	Env    []string          `json:"Env"`
+	Labels map[string]string `json:"Labels"`
+	// Container output is not multiplexed if TTY is allocated
+	Tty bool `json:"Tty"`
+}
+
+// Event returned by /events
+type ContainerEvent struct {
+	// create, start, die, destroy...
+	Action string `json:"Action"`
+	Actor  struct {
+		ID string `json:"ID"`
+		// container name and labels
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+// Processes running in container returned by /containers/{id}/top
+type ContainerTop struct {
+	Titles    []string   `json:"Titles"`
+	Processes [][]string `json:"Processes"`
+}
+
+// Bind mount or volume of the container
+type MountPoint struct {
+	// bind, volume, tmpfs...
+	Type        string `json:"Type"`
+	Name        string `json:"Name"`
+	Source      string `json:"Source"`
+	Destination string `json:"Destination"`
+	RW          bool   `json:"RW"`
+}
+
+// Docker system information returned by /info
+type DockerInfo struct {
+	DockerRootDir string `json:"DockerRootDir"`
+}
+
+// File from the container content fetched on mount.
+type staticFile struct {
+	mode os.FileMode
+	// device number for char and block devices
+	rdev uint32
+	// symlink target
+	link string
+}
+
+// Converts os.FileMode to the unix mode (S_IF* file type along with permission bits) used by FUSE.
+func fuseMode(mode os.FileMode) uint32 {
+	result := uint32(mode.Perm())
+	switch {
+	case mode&os.ModeSymlink != 0:
+		result |= fuse.S_IFLNK
+	case mode.IsDir():
+		result |= fuse.S_IFDIR
+	case mode&os.ModeNamedPipe != 0:
+		result |= fuse.S_IFIFO
+	case mode&os.ModeSocket != 0:
+		result |= syscall.S_IFSOCK
+	case mode&os.ModeCharDevice != 0:
+		result |= syscall.S_IFCHR
+	case mode&os.ModeDevice != 0:
+		result |= syscall.S_IFBLK
+	default:
+		result |= fuse.S_IFREG
+	}
+	if mode&os.ModeSetuid != 0 {
+		result |= syscall.S_ISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		result |= syscall.S_ISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		result |= syscall.S_ISVTX
+	}
+	return result
+}
+
+// Encodes device number the same way Linux does.
+func mkdev(major, minor int64) uint32 {
+	return uint32((minor & 0xff) | ((major & 0xfff) << 8) | ((minor &^ 0xff) << 12))
+}
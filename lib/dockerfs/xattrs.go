@@ -0,0 +1,177 @@
+package dockerfs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/plesk/docker-fs/lib/log"
+)
+
+// Virtual extended attributes describing where the file came from.
+const (
+	xattrPrefix = "user.dockerfs."
+
+	// Kind of the change reported by /containers/{id}/changes: added, modified, removed or unchanged
+	XattrChange = xattrPrefix + "change"
+	// Octal mode of the file in the container content fetched on mount
+	XattrMode = xattrPrefix + "mode"
+	// Symlink target
+	XattrLink = xattrPrefix + "link"
+	// Container ID (or name) the FS is mounted from
+	XattrContainer = xattrPrefix + "container"
+	// Time (RFC3339) the attributes were fetched from docker
+	XattrUpdated = xattrPrefix + "updated"
+	// Bind mount or volume the file is located in: type, source and mode, e.g. "volume mysql_data rw"
+	XattrMount = xattrPrefix + "mount"
+)
+
+// Extended attributes of so many paths are cached by default
+const defaultXattrsCacheSize = 1000
+
+type xattrsEntry struct {
+	attrs   map[string][]byte
+	updated time.Time
+}
+
+// Xattrs returns extended attributes of the path.
+// Those are virtual user.dockerfs.* attributes and real user.* attributes
+// passed through from tar PAX headers of the archive API.
+func (m *Mng) Xattrs(path string) (map[string][]byte, error) {
+	path = filepath.Clean(path)
+
+	m.xattrsMutex.Lock()
+	entry, ok := m.xattrs[path]
+	m.xattrsMutex.Unlock()
+	if ok && time.Now().Before(entry.updated.Add(m.changesUpdateInterval)) {
+		return entry.attrs, nil
+	}
+
+	changes, err := m.FsChanges()
+	if err != nil {
+		return nil, err
+	}
+	stat, err := m.pathAttrs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := "unchanged"
+	if k, ok := changes.Kind(path); ok {
+		kind = strings.ToLower(k.String())
+	}
+	entry = &xattrsEntry{
+		attrs: map[string][]byte{
+			XattrChange:    []byte(kind),
+			XattrContainer: []byte(m.containerId()),
+		},
+		updated: time.Now(),
+	}
+	entry.attrs[XattrUpdated] = []byte(entry.updated.Format(time.RFC3339))
+	if static, ok := m.staticFile(path); ok {
+		entry.attrs[XattrMode] = []byte(fmt.Sprintf("%06o", fuseMode(static.mode)))
+	}
+	if stat.LinkTarget != "" {
+		entry.attrs[XattrLink] = []byte(stat.LinkTarget)
+	}
+	if mnt := m.mountOf(path); mnt != nil {
+		entry.attrs[XattrMount] = []byte(mountXattr(mnt))
+	}
+
+	var paxAttrs map[string]string
+	if !stat.Mode.IsDir() {
+		if paxAttrs, err = m.content.GetPathXattrs(path); err != nil {
+			log.Printf("[warning] Failed to get extended attributes of %q: %v", path, err)
+		}
+	}
+	for name, value := range paxAttrs {
+		// only user namespace is passed through, virtual attributes can't be overridden
+		if strings.HasPrefix(name, "user.") && !strings.HasPrefix(name, xattrPrefix) {
+			entry.attrs[name] = []byte(value)
+		}
+	}
+
+	m.cacheXattrs(path, entry)
+	return entry.attrs, nil
+}
+
+// Caches attributes of the path, outdated entries are dropped once the cache is full.
+func (m *Mng) cacheXattrs(path string, entry *xattrsEntry) {
+	m.xattrsMutex.Lock()
+	defer m.xattrsMutex.Unlock()
+	if len(m.xattrs) >= m.xattrsCacheSize {
+		for p, e := range m.xattrs {
+			if !entry.updated.Before(e.updated.Add(m.changesUpdateInterval)) {
+				delete(m.xattrs, p)
+			}
+		}
+		// all entries are up to date, drop some of them
+		for p := range m.xattrs {
+			if len(m.xattrs) < m.xattrsCacheSize {
+				break
+			}
+			delete(m.xattrs, p)
+		}
+	}
+	m.xattrs[path] = entry
+}
+
+// getxattr implements fs.NodeGetxattrer for nodes of the container FS.
+func (m *Mng) getxattr(path, attr string, dest []byte) (uint32, syscall.Errno) {
+	if !strings.HasPrefix(attr, "user.") {
+		// security.*, system.* etc. are requested by the kernel and tools like ls,
+		// don't go to docker for them.
+		return 0, syscall.ENODATA
+	}
+	attrs, errno := m.xattrsErrno(path)
+	if errno != 0 {
+		return 0, errno
+	}
+	value, ok := attrs[attr]
+	if !ok {
+		return 0, syscall.ENODATA
+	}
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+	return uint32(copy(dest, value)), 0
+}
+
+// listxattr implements fs.NodeListxattrer for nodes of the container FS.
+func (m *Mng) listxattr(path string, dest []byte) (uint32, syscall.Errno) {
+	attrs, errno := m.xattrsErrno(path)
+	if errno != 0 {
+		return 0, errno
+	}
+	var names []string
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list []byte
+	for _, name := range names {
+		list = append(list, name...)
+		list = append(list, 0)
+	}
+	if len(dest) < len(list) {
+		return uint32(len(list)), syscall.ERANGE
+	}
+	return uint32(copy(dest, list)), 0
+}
+
+func (m *Mng) xattrsErrno(path string) (map[string][]byte, syscall.Errno) {
+	attrs, err := m.Xattrs(path)
+	if errors.As(err, &ErrorNotFound{}) {
+		return nil, syscall.ENOENT
+	}
+	if err != nil {
+		log.Printf("[error] Failed to get extended attributes of %q: %v", path, err)
+		return nil, syscall.EIO
+	}
+	return attrs, 0
+}
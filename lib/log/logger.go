@@ -4,6 +4,7 @@ import (
 	"fmt"
 	golog "log"
 	"strings"
+	"sync/atomic"
 )
 
 type LogLevel int
@@ -36,33 +37,52 @@ func (l LogLevel) String() string {
 }
 
 var (
-	// Default level
-	Level     = Warning
+	// current LogLevel, Warning by default; it's changed at runtime (e.g. through control API),
+	// so it's accessed atomically
+	level     = int32(Warning)
 	allLevels = []LogLevel{Critical, Error, Warning, Info, Debug, Trace}
 )
 
-func SetLevel(level string) error {
+// Level returns the current logging level.
+func Level() LogLevel {
+	return LogLevel(atomic.LoadInt32(&level))
+}
+
+func SetLevel(name string) error {
+	l, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	SetLogLevel(l)
+	return nil
+}
+
+func SetLogLevel(l LogLevel) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+// ParseLevel returns level by its name or prefix of the name, e.g. "warn".
+func ParseLevel(level string) (LogLevel, error) {
 	lvl := strings.ToLower(level)
 	for _, l := range allLevels {
 		if strings.HasPrefix(l.String(), lvl) {
-			Level = l
-			return nil
+			return l, nil
 		}
 	}
-	return fmt.Errorf("Unknown LogLevel: %v", level)
+	return Warning, fmt.Errorf("Unknown LogLevel: %v", level)
 }
 
 func Printf(format string, v ...interface{}) {
 	for _, lvl := range allLevels {
 		if strings.HasPrefix(format, "["+lvl.String()+"] ") {
-			if Level >= lvl {
+			if Level() >= lvl {
 				golog.Printf(format, v...)
 			}
 			return
 		}
 	}
 	// Warning level by default
-	if Level >= Warning {
+	if Level() >= Warning {
 		golog.Printf(format, v...)
 	}
 }
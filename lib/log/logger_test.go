@@ -2,8 +2,11 @@ package log
 
 import (
 	"fmt"
+	"io/ioutil"
 	golog "log"
+	"os"
 	"strings"
+	"testing"
 )
 
 func ExamplePrintf() {
@@ -28,3 +31,27 @@ func ExamplePrintf() {
 	// [warning] something that may require some attention
 	// [info] message about what the program is doing
 }
+
+// Level is changed at runtime while other goroutines log, see `go test -race`.
+func TestSetLevelConcurrently(t *testing.T) {
+	golog.SetOutput(ioutil.Discard)
+	defer golog.SetOutput(os.Stderr)
+	defer SetLogLevel(Level())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			Printf("[debug] message %d", i)
+		}
+	}()
+	for _, name := range []string{"debug", "error", "trace"} {
+		if err := SetLevel(name); err != nil {
+			t.Errorf("SetLevel(%q) failed: %v", name, err)
+		}
+	}
+	<-done
+	if Level() != Trace {
+		t.Errorf("Incorrect level: expected %v, actual %v", Trace, Level())
+	}
+}
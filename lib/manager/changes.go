@@ -0,0 +1,185 @@
+package manager
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/plesk/docker-fs/lib/dockerfs"
+	"github.com/plesk/docker-fs/lib/log"
+)
+
+type ExportOptions struct {
+	// Bundle format: "tar" (default) or "patch"
+	Format string
+	// Export only these paths (directories or glob patterns), all changes are exported if empty
+	Paths []string
+}
+
+// ExportChanges writes files added or modified in container and removed ones as a bundle.
+// Removed files are marked by whiteouts like in image layers (.wh.<name> entries),
+// they're written first, so removals are replayed before files are restored.
+// Tar bundles can be replayed with ImportChanges, patches are meant for `git apply`
+// (only regular files are included in this case, binary ones as git binary patches).
+func (m *Manager) ExportChanges(containerId string, opts ExportOptions, w io.Writer) error {
+	switch opts.Format {
+	case "", "tar":
+	case "patch":
+		return m.Diff(containerId, DiffOptions{Paths: opts.Paths, Removed: true, Binary: true}, w)
+	default:
+		return fmt.Errorf("Unknown bundle format: %q", opts.Format)
+	}
+
+	docker, err := m.docker(containerId)
+	if err != nil {
+		return err
+	}
+	changes, err := docker.GetFsChanges()
+	if err != nil {
+		return err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	writer := tar.NewWriter(w)
+	removed := make(map[string]bool)
+	for _, change := range changes {
+		if change.Kind != dockerfs.FileRemoved || !matchPaths(change.Path, opts.Paths) {
+			continue
+		}
+		removed[change.Path] = true
+		if removed[filepath.Dir(change.Path)] {
+			// removed with the directory
+			continue
+		}
+		dir, name := filepath.Split(strings.TrimPrefix(change.Path, "/"))
+		if err := writer.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     dir + dockerfs.WhiteoutPrefix + name,
+			Mode:     0644,
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, change := range changes {
+		if change.Kind == dockerfs.FileRemoved || !matchPaths(change.Path, opts.Paths) {
+			continue
+		}
+		stat, err := docker.GetPathAttrs(change.Path)
+		if errors.As(err, &dockerfs.ErrorNotFound{}) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:    strings.TrimPrefix(change.Path, "/"),
+			Mode:    int64(stat.Mode.Perm()),
+			ModTime: stat.Mtime,
+		}
+		var data []byte
+		switch {
+		case stat.Mode.IsDir():
+			if change.Kind != dockerfs.FileAdded {
+				// content of modified directories is exported file by file
+				continue
+			}
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case stat.Mode&os.ModeSymlink != 0:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = stat.LinkTarget
+		case stat.Mode.IsRegular():
+			hdr.Typeflag = tar.TypeReg
+			if data, err = readFile(docker, change.Path); err != nil {
+				return err
+			}
+			hdr.Size = int64(len(data))
+		default:
+			log.Printf("[warning] Skip special file %q", change.Path)
+			continue
+		}
+		if err := writer.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// ImportChanges replays tar bundle created by ExportChanges onto the container:
+// paths marked by whiteouts are deleted, then files, directories and symlinks are restored.
+func (m *Manager) ImportChanges(containerId string, r io.Reader) error {
+	docker, err := m.docker(containerId)
+	if err != nil {
+		return err
+	}
+	reader := tar.NewReader(r)
+	// removals are batched until the first restored entry
+	var removed []string
+	for {
+		hdr, err := reader.Next()
+		if err == io.EOF {
+			return removePaths(docker, removed)
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot read bundle: %w", err)
+		}
+		path := "/" + strings.Trim(hdr.Name, "/")
+		dir, name := filepath.Split(path)
+		if name == dockerfs.WhiteoutOpaque {
+			log.Printf("[warning] Skip unsupported opaque whiteout %q", hdr.Name)
+			continue
+		}
+		if strings.HasPrefix(name, dockerfs.WhiteoutPrefix) {
+			removed = append(removed, dir+strings.TrimPrefix(name, dockerfs.WhiteoutPrefix))
+			continue
+		}
+		if err := removePaths(docker, removed); err != nil {
+			return err
+		}
+		removed = nil
+
+		switch {
+		case hdr.Typeflag == tar.TypeDir:
+			err = docker.MakeDir(path, uint32(hdr.Mode))
+		case hdr.Typeflag == tar.TypeSymlink:
+			err = docker.MakeSymlink(path, hdr.Linkname)
+		case hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA:
+			var data []byte
+			if data, err = ioutil.ReadAll(reader); err != nil {
+				return err
+			}
+			err = docker.SaveFile(path, data, &dockerfs.ContainerPathStat{Mode: os.FileMode(hdr.Mode).Perm()})
+		default:
+			log.Printf("[warning] Skip unsupported bundle entry %q", hdr.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot restore %q: %w", path, err)
+		}
+	}
+}
+
+// Deletes paths marked by whiteouts.
+// Docker API has no delete operation, so it's done by rm executed in container.
+func removePaths(docker dockerfs.DockerMng, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	output, code, err := docker.Exec(append([]string{"rm", "-rf", "--"}, paths...))
+	if err != nil {
+		return fmt.Errorf("Cannot remove files: %w", err)
+	}
+	if code != 0 {
+		return fmt.Errorf("Cannot remove files: rm exited with code %d: %s", code, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
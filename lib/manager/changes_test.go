@@ -0,0 +1,256 @@
+package manager
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/plesk/docker-fs/lib/dockerfs"
+)
+
+// dockerMock serves container content from a local directory,
+// methods which are not used by export and import panic.
+type dockerMock struct {
+	dockerfs.DockerMng
+	root    string
+	changes dockerfs.FsChanges
+}
+
+func (d *dockerMock) GetFsChanges() (dockerfs.FsChanges, error) {
+	return d.changes, nil
+}
+
+func (d *dockerMock) GetPathAttrs(path string) (*dockerfs.ContainerPathStat, error) {
+	fullpath := filepath.Join(d.root, path)
+	fi, err := os.Lstat(fullpath)
+	if os.IsNotExist(err) {
+		return nil, dockerfs.ErrorNotFound{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	stat := &dockerfs.ContainerPathStat{Name: fi.Name(), Size: fi.Size(), Mode: fi.Mode(), Mtime: fi.ModTime()}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if stat.LinkTarget, err = os.Readlink(fullpath); err != nil {
+			return nil, err
+		}
+	}
+	return stat, nil
+}
+
+func (d *dockerMock) GetFile(path string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.root, path))
+}
+
+func (d *dockerMock) SaveFile(path string, data []byte, stat *dockerfs.ContainerPathStat) error {
+	return ioutil.WriteFile(filepath.Join(d.root, path), data, stat.Mode)
+}
+
+func (d *dockerMock) MakeDir(path string, mode uint32) error {
+	return os.Mkdir(filepath.Join(d.root, path), os.FileMode(mode))
+}
+
+func (d *dockerMock) MakeSymlink(path, target string) error {
+	return os.Symlink(target, filepath.Join(d.root, path))
+}
+
+// Only `rm -rf -- <paths>` is supported.
+func (d *dockerMock) Exec(cmd []string) ([]byte, int, error) {
+	if len(cmd) < 3 || strings.Join(cmd[:3], " ") != "rm -rf --" {
+		return nil, 0, fmt.Errorf("Unexpected command: %v", cmd)
+	}
+	for _, path := range cmd[3:] {
+		if err := os.RemoveAll(filepath.Join(d.root, path)); err != nil {
+			return []byte(err.Error()), 1, nil
+		}
+	}
+	return nil, 0, nil
+}
+
+func (d *dockerMock) ContainerInspect(size bool) (*dockerfs.ContainerInfo, error) {
+	return &dockerfs.ContainerInfo{Image: "image"}, nil
+}
+
+// Container created from the image is served by the "original" mock.
+func (d *dockerMock) ContainerCreate(image string) (string, error) {
+	return "original", nil
+}
+
+func (d *dockerMock) ContainerRemove() error {
+	return nil
+}
+
+// Creates files in the directory: name ending with / is a directory, "-> target" content is a symlink.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		switch {
+		case strings.HasSuffix(name, "/"):
+			err = os.MkdirAll(path, 0755)
+		case strings.HasPrefix(content, "-> "):
+			err = os.Symlink(strings.TrimPrefix(content, "-> "), path)
+		default:
+			err = ioutil.WriteFile(path, []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Returns files of the directory in the form of writeTree, symlinks are skipped unless links is set.
+func readTree(t *testing.T, dir string, links bool) map[string]string {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		name := path[len(dir)+1:]
+		switch {
+		case fi.IsDir():
+			files[name+"/"] = ""
+		case fi.Mode()&os.ModeSymlink != 0:
+			if links {
+				target, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				files[name] = "-> " + target
+			}
+		default:
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files[name] = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestExportImportChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manager_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	image := map[string]string{
+		"file1.txt":      "one\n",
+		"dir2/file2.txt": "two\n",
+		"old.txt":        "old\n",
+		"olddir/a.txt":   "a\n",
+		"dir2/data.bin":  "\x00\x01old",
+		"old.bin":        "\x00\x02",
+	}
+	container := map[string]string{
+		"file1.txt":      "one\n",
+		"dir2/file2.txt": "2\n",
+		"dir2/file4.txt": "four\n",
+		"dir3/file5.txt": "five\n",
+		"link":           "-> file1.txt",
+		"dir2/data.bin":  "\x00\x01new\xff",
+		"new.bin":        strings.Repeat("\x00binary", 20),
+	}
+	changes := dockerfs.FsChanges{
+		{Path: "/dir2", Kind: dockerfs.FileModified},
+		{Path: "/dir2/data.bin", Kind: dockerfs.FileModified},
+		{Path: "/new.bin", Kind: dockerfs.FileAdded},
+		{Path: "/old.bin", Kind: dockerfs.FileRemoved},
+		{Path: "/dir2/file2.txt", Kind: dockerfs.FileModified},
+		{Path: "/dir2/file4.txt", Kind: dockerfs.FileAdded},
+		{Path: "/dir3", Kind: dockerfs.FileAdded},
+		{Path: "/dir3/file5.txt", Kind: dockerfs.FileAdded},
+		{Path: "/link", Kind: dockerfs.FileAdded},
+		{Path: "/old.txt", Kind: dockerfs.FileRemoved},
+		{Path: "/olddir", Kind: dockerfs.FileRemoved},
+		{Path: "/olddir/a.txt", Kind: dockerfs.FileRemoved},
+	}
+	mocks := map[string]*dockerMock{
+		"source":   {root: filepath.Join(dir, "source"), changes: changes},
+		"original": {root: filepath.Join(dir, "original")},
+		// another container created from the same image
+		"target": {root: filepath.Join(dir, "target")},
+	}
+	writeTree(t, mocks["source"].root, container)
+	writeTree(t, mocks["original"].root, image)
+	writeTree(t, mocks["target"].root, image)
+	mng := New(nil)
+	mng.newDocker = func(containerId string) (dockerfs.DockerMng, error) {
+		if mock, ok := mocks[containerId]; ok {
+			return mock, nil
+		}
+		return nil, fmt.Errorf("Unexpected container %v", containerId)
+	}
+
+	t.Run("tar", func(t *testing.T) {
+		var bundle bytes.Buffer
+		if err := mng.ExportChanges("source", ExportOptions{}, &bundle); err != nil {
+			t.Fatalf("ExportChanges() failed: %v", err)
+		}
+		// removed files are marked like in image layers, content of removed directories is not listed
+		var whiteouts []string
+		reader := tar.NewReader(bytes.NewReader(bundle.Bytes()))
+		for {
+			hdr, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Cannot read bundle: %v", err)
+			}
+			if strings.Contains(hdr.Name, dockerfs.WhiteoutPrefix) {
+				whiteouts = append(whiteouts, hdr.Name)
+			}
+		}
+		if expected := []string{".wh.old.bin", ".wh.old.txt", ".wh.olddir"}; !reflect.DeepEqual(whiteouts, expected) {
+			t.Errorf("Incorrect whiteouts exported: expected %v, actual %v", expected, whiteouts)
+		}
+
+		if err := mng.ImportChanges("target", &bundle); err != nil {
+			t.Fatalf("ImportChanges() failed: %v", err)
+		}
+		expected, actual := readTree(t, mocks["source"].root, true), readTree(t, mocks["target"].root, true)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Incorrect changes imported:\nexpected %q\nactual   %q", expected, actual)
+		}
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		if _, err := exec.LookPath("git"); err != nil {
+			t.Skip("git is not installed")
+		}
+		var patch bytes.Buffer
+		if err := mng.ExportChanges("source", ExportOptions{Format: "patch"}, &patch); err != nil {
+			t.Fatalf("ExportChanges() failed: %v", err)
+		}
+		root := filepath.Join(dir, "patched")
+		writeTree(t, root, image)
+		cmd := exec.Command("git", "apply", "-")
+		cmd.Dir, cmd.Stdin = root, &patch
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git apply failed: %v\n%s\npatch:\n%s", err, output, patch.String())
+		}
+		// patches include only regular files, directories left empty are removed by git
+		expected, actual := readTree(t, mocks["source"].root, false), readTree(t, root, false)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Incorrect changes applied:\nexpected %q\nactual   %q", expected, actual)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Compose files looked up in the directory, in order of preference
+var composeFiles = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// Characters not allowed in project names
+var projectNameRe = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// Variables interpolated in compose files: $$, $VAR, ${VAR}, ${VAR-default} and ${VAR:-default}
+var composeVarRe = regexp.MustCompile(`\$(\$|[A-Za-z_][A-Za-z0-9_]*|\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?-)([^}]*))?\})`)
+
+// DetectComposeProject returns name of docker compose project defined in the directory
+// the same way `docker compose` does: COMPOSE_PROJECT_NAME, top-level `name` of the compose files
+// (COMPOSE_FILE or the default one in the directory) or name of the directory of the first file.
+func DetectComposeProject(dir string) (string, error) {
+	if name := os.Getenv("COMPOSE_PROJECT_NAME"); name != "" {
+		return name, nil
+	}
+	files, err := composeProjectFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	// later files override earlier ones
+	project := ""
+	for _, file := range files {
+		name, err := composeProjectName(file)
+		if err != nil {
+			return "", err
+		}
+		if name != "" {
+			project = name
+		}
+	}
+	if project != "" {
+		return project, nil
+	}
+	abs, err := filepath.Abs(filepath.Dir(files[0]))
+	if err != nil {
+		return "", err
+	}
+	return normalizeProjectName(filepath.Base(abs)), nil
+}
+
+// Returns compose files of the project: listed in COMPOSE_FILE (relative to the directory)
+// or the first default one found in the directory.
+func composeProjectFiles(dir string) ([]string, error) {
+	if list := os.Getenv("COMPOSE_FILE"); list != "" {
+		separator := os.Getenv("COMPOSE_PATH_SEPARATOR")
+		if separator == "" {
+			separator = string(os.PathListSeparator)
+		}
+		var files []string
+		for _, file := range strings.Split(list, separator) {
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(dir, file)
+			}
+			files = append(files, file)
+		}
+		return files, nil
+	}
+	for _, name := range composeFiles {
+		file := filepath.Join(dir, name)
+		if _, err := os.Stat(file); err == nil {
+			return []string{file}, nil
+		}
+	}
+	return nil, fmt.Errorf("Compose file is not found in %v", dir)
+}
+
+// Returns top-level `name` of the compose file with variables interpolated, it's empty if the name is not set.
+func composeProjectName(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	var compose struct {
+		Name string `yaml:"name"`
+	}
+	if err := yaml.Unmarshal(data, &compose); err != nil {
+		return "", fmt.Errorf("Cannot parse compose file %v: %w", file, err)
+	}
+	return interpolateComposeVars(compose.Name), nil
+}
+
+// Replaces variables with values from environment like compose does.
+func interpolateComposeVars(value string) string {
+	return composeVarRe.ReplaceAllStringFunc(value, func(match string) string {
+		groups := composeVarRe.FindStringSubmatch(match)
+		switch {
+		case groups[1] == "$":
+			return "$"
+		case groups[2] == "":
+			return os.Getenv(groups[1])
+		}
+		actual, ok := os.LookupEnv(groups[2])
+		if groups[3] == ":-" && actual == "" || groups[3] == "-" && !ok {
+			return groups[4]
+		}
+		return actual
+	})
+}
+
+// Makes a valid project name of the directory name like compose does.
+func normalizeProjectName(name string) string {
+	return strings.TrimLeft(projectNameRe.ReplaceAllString(strings.ToLower(name), ""), "_-")
+}
@@ -0,0 +1,68 @@
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectComposeProject(t *testing.T) {
+	testdata := []struct {
+		name string
+		// files created in "My_App.v2" directory
+		files    map[string]string
+		env      map[string]string
+		expected string
+	}{
+		{"directory", map[string]string{"compose.yaml": "services: {}\n"}, nil, "my_appv2"},
+		{"name key", map[string]string{"compose.yaml": "name: shop # comment\nservices:\n  name: web\n"}, nil, "shop"},
+		{"quoted name", map[string]string{"docker-compose.yml": "name: 'shop'\n"}, nil, "shop"},
+		{"preferred file", map[string]string{"compose.yaml": "name: one\n", "docker-compose.yml": "name: two\n"}, nil, "one"},
+		{"nested name", map[string]string{"compose.yaml": "services:\n  web:\n    name: web\n"}, nil, "my_appv2"},
+		{"env override", map[string]string{"compose.yaml": "name: shop\n"}, map[string]string{"COMPOSE_PROJECT_NAME": "other"}, "other"},
+		{"interpolation", map[string]string{"compose.yaml": "name: shop-${STAGE}\n"}, map[string]string{"STAGE": "dev"}, "shop-dev"},
+		{"interpolation default", map[string]string{"compose.yaml": "name: shop-${STAGE:-prod}$$\n"}, nil, "shop-prod$"},
+		{"compose file", map[string]string{"compose.yaml": "name: one\n", "base.yaml": "name: two\n", "dev/dev.yaml": "services: {}\n"},
+			map[string]string{"COMPOSE_FILE": "base.yaml:dev/dev.yaml"}, "two"},
+		{"compose file directory", map[string]string{"dev/dev.yaml": "services: {}\n"},
+			map[string]string{"COMPOSE_FILE": "dev/dev.yaml"}, "dev"},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "manager_test_")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+			dir := filepath.Join(tmp, "My_App.v2")
+			writeTree(t, dir, test.files)
+			for _, key := range []string{"COMPOSE_PROJECT_NAME", "COMPOSE_FILE", "STAGE"} {
+				os.Unsetenv(key)
+				if value, ok := test.env[key]; ok {
+					os.Setenv(key, value)
+				}
+				defer os.Unsetenv(key)
+			}
+
+			project, err := DetectComposeProject(dir)
+			if err != nil {
+				t.Fatalf("DetectComposeProject() failed: %v", err)
+			}
+			if project != test.expected {
+				t.Errorf("Incorrect project: expected %q, actual %q", test.expected, project)
+			}
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "manager_test_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if project, err := DetectComposeProject(dir); err == nil {
+			t.Errorf("Project %q is detected without compose file", project)
+		}
+	})
+}
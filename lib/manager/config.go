@@ -0,0 +1,206 @@
+package manager
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Offered by TUI if mount point template is not configured
+const defaultMountPoint = "./mount-{{.Name}}"
+
+// Config keeps defaults of mount options and profiles overriding them for some containers and images,
+// e.g. ~/.config/docker-fs/config.yaml:
+//
+//	engine: unix:/var/run/docker.sock
+//	mount_point: ~/mnt/{{.Name}}
+//	cache: metadata
+//	read_only: true
+//	profiles:
+//	  - match: {name: "web-*"}
+//	    read_only: false
+//	    rewrite_symlinks: true
+//	  - match: {image: "postgres:*", labels: ["com.docker.compose.project=shop"]}
+//	    uid: 999
+//	    gid: 999
+//	watch:
+//	  - name: "web-*"
+//	    mount_point: ~/mnt/{{.Name}}
+//	    options: {read_only: false}
+type Config struct {
+	// docker engine socket, e.g. unix:/var/run/docker.sock (only unix sockets are supported)
+	Engine string `yaml:"engine"`
+	// template of mount point offered by TUI, fields of Container are available
+	MountPoint string `yaml:"mount_point"`
+
+	MountOptions `yaml:",inline"`
+	// applied in order, so later profiles override earlier ones
+	Profiles []Profile `yaml:"profiles"`
+	// rules of the watcher, managed by `watch add` and `watch rm`
+	Watch []WatchRule `yaml:"watch"`
+}
+
+// Profile overrides mount options for containers and images it matches.
+type Profile struct {
+	Match        ProfileMatch `yaml:"match"`
+	MountOptions `yaml:",inline"`
+}
+
+// ProfileMatch selects containers and images, all of the set fields must match.
+type ProfileMatch struct {
+	// glob pattern of container name, e.g. "web-*", profile with name never matches images
+	Name string `yaml:"name"`
+	// glob pattern of image name, e.g. "postgres:*"
+	Image string `yaml:"image"`
+	// labels container must have: "key" or "key=value", profile with labels never matches images
+	Labels []string `yaml:"labels"`
+}
+
+// ConfigPath returns default path of the config: ~/.config/docker-fs/config.yaml
+func ConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "docker-fs", "config.yaml"), nil
+}
+
+// LoadConfig reads the config, empty config is returned if the file doesn't exist.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("Cannot parse config %v: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid config %v: %w", path, err)
+	}
+	return config, nil
+}
+
+func (c *Config) validate() error {
+	if c.Engine != "" && !strings.HasPrefix(c.Engine, "unix:") {
+		return fmt.Errorf("Unsupported engine %q, only unix sockets (unix:/path) are supported", c.Engine)
+	}
+	if _, err := template.New("mount point").Parse(c.MountPoint); err != nil {
+		return fmt.Errorf("Invalid mount point template: %w", err)
+	}
+	if err := c.MountOptions.validate(); err != nil {
+		return err
+	}
+	for i, profile := range c.Profiles {
+		if _, err := path.Match(profile.Match.Name, ""); err != nil {
+			return fmt.Errorf("Profile %d: invalid name pattern %q: %w", i+1, profile.Match.Name, err)
+		}
+		if _, err := path.Match(profile.Match.Image, ""); err != nil {
+			return fmt.Errorf("Profile %d: invalid image pattern %q: %w", i+1, profile.Match.Image, err)
+		}
+		if err := profile.MountOptions.validate(); err != nil {
+			return fmt.Errorf("Profile %d: %w", i+1, err)
+		}
+	}
+	for i := range c.Watch {
+		if err := c.Watch[i].Validate(); err != nil {
+			return fmt.Errorf("Watch rule %d: %w", i+1, err)
+		}
+		if err := c.Watch[i].Options.validate(); err != nil {
+			return fmt.Errorf("Watch rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (p *ProfileMatch) matches(name, image string, labels map[string]string) bool {
+	if p.Name != "" {
+		if matched, _ := path.Match(p.Name, name); name == "" || !matched {
+			return false
+		}
+	}
+	if p.Image != "" {
+		if matched, _ := path.Match(p.Image, image); !matched {
+			return false
+		}
+	}
+	return matchLabels(p.Labels, labels)
+}
+
+// Options returns global mount options, they are used for mounts of several containers.
+func (c *Config) Options() MountOptions {
+	var opts MountOptions
+	opts.Override(c.MountOptions)
+	return opts
+}
+
+// ContainerOptions returns mount options of the container: global ones overridden by matching profiles.
+func (c *Config) ContainerOptions(name, image string, labels map[string]string) MountOptions {
+	opts := c.Options()
+	for i := range c.Profiles {
+		if c.Profiles[i].Match.matches(name, image, labels) {
+			opts.Override(c.Profiles[i].MountOptions)
+		}
+	}
+	return opts
+}
+
+// ImageOptions returns mount options of the image, only profiles matching by image are applied.
+func (c *Config) ImageOptions(image string) MountOptions {
+	return c.ContainerOptions("", image, nil)
+}
+
+// DefaultMountPoint returns mount point of the container offered by TUI.
+func (c *Config) DefaultMountPoint(ct *Container) (string, error) {
+	mountPoint := c.MountPoint
+	if mountPoint == "" {
+		mountPoint = defaultMountPoint
+	}
+	tmpl, err := template.New("mount point").Option("missingkey=error").Parse(mountPoint)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ct); err != nil {
+		return "", err
+	}
+	return expandHome(buf.String())
+}
+
+// Replaces leading ~/ with home directory of the user.
+func expandHome(path string) (string, error) {
+	if !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[2:]), nil
+}
+
+// Checks that labels match selectors: "key" requires the label, "key=value" requires its value.
+func matchLabels(selectors []string, labels map[string]string) bool {
+	for _, selector := range selectors {
+		key, value := selector, ""
+		hasValue := false
+		if pos := strings.Index(selector, "="); pos >= 0 {
+			key, value, hasValue = selector[:pos], selector[pos+1:], true
+		}
+		actual, ok := labels[key]
+		if !ok || hasValue && actual != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testConfig = `
+engine: unix:/var/run/docker.sock
+cache: metadata
+read_only: true
+uid: 1000
+profiles:
+  - match: {name: "web-*"}
+    read_only: false
+    rewrite_symlinks: true
+  - match: {image: "postgres:*"}
+    uid: 999
+  - match: {labels: ["com.docker.compose.project=shop"]}
+    uid: 33
+  - match: {name: "web-1"}
+    read_only: true
+`
+
+func loadTestConfig(t *testing.T, content string) (*Config, error) {
+	dir, err := ioutil.TempDir("", "manager_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+func boolOption(value bool) *bool {
+	return &value
+}
+
+func stringOption(value string) *string {
+	return &value
+}
+
+func idOption(value uint32) *uint32 {
+	return &value
+}
+
+func TestContainerOptions(t *testing.T) {
+	config, err := loadTestConfig(t, testConfig)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	shop := map[string]string{"com.docker.compose.project": "shop"}
+	for _, test := range []struct {
+		name, image string
+		labels      map[string]string
+		expected    MountOptions
+	}{
+		// only global options
+		{"db", "mysql:8", nil, MountOptions{Cache: stringOption("metadata"), ReadOnly: boolOption(true), Uid: idOption(1000)}},
+		{"web-2", "nginx", nil, MountOptions{Cache: stringOption("metadata"), ReadOnly: boolOption(false), RewriteSymlinks: boolOption(true), Uid: idOption(1000)}},
+		// later profile overrides the earlier one
+		{"web-1", "nginx", nil, MountOptions{Cache: stringOption("metadata"), ReadOnly: boolOption(true), RewriteSymlinks: boolOption(true), Uid: idOption(1000)}},
+		{"db", "postgres:13", shop, MountOptions{Cache: stringOption("metadata"), ReadOnly: boolOption(true), Uid: idOption(33)}},
+		{"db", "postgres:13", nil, MountOptions{Cache: stringOption("metadata"), ReadOnly: boolOption(true), Uid: idOption(999)}},
+		{"web-1", "postgres:13", shop, MountOptions{Cache: stringOption("metadata"), ReadOnly: boolOption(true), RewriteSymlinks: boolOption(true), Uid: idOption(33)}},
+	} {
+		opts := config.ContainerOptions(test.name, test.image, test.labels)
+		if !reflect.DeepEqual(opts, test.expected) {
+			t.Errorf("Incorrect options of %v (%v, %v):\nexpected %+v\nactual   %+v", test.name, test.image, test.labels, test.expected, opts)
+		}
+	}
+
+	// profiles matching by name and labels are not applied to images
+	expected := MountOptions{Cache: stringOption("metadata"), ReadOnly: boolOption(true), Uid: idOption(999)}
+	if opts := config.ImageOptions("postgres:13"); !reflect.DeepEqual(opts, expected) {
+		t.Errorf("Incorrect options of image:\nexpected %+v\nactual   %+v", expected, opts)
+	}
+	expected = MountOptions{Cache: stringOption("metadata"), ReadOnly: boolOption(true), Uid: idOption(1000)}
+	if opts := config.ImageOptions("web-1"); !reflect.DeepEqual(opts, expected) {
+		t.Errorf("Incorrect options of image:\nexpected %+v\nactual   %+v", expected, opts)
+	}
+}
+
+func TestOverrideMountOptions(t *testing.T) {
+	opts := MountOptions{ReadOnly: boolOption(true), Cache: stringOption("metadata"), Uid: idOption(1000)}
+	other := MountOptions{Daemonize: true, ReadOnly: boolOption(false), Gid: idOption(33)}
+	opts.Override(other)
+	expected := MountOptions{Daemonize: true, ReadOnly: boolOption(false), Cache: stringOption("metadata"), Uid: idOption(1000), Gid: idOption(33)}
+	if !reflect.DeepEqual(opts, expected) {
+		t.Errorf("Incorrect options overridden:\nexpected %+v\nactual   %+v", expected, opts)
+	}
+	// values are not shared
+	*other.ReadOnly = true
+	if *opts.ReadOnly {
+		t.Errorf("Overridden options share values with the source")
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for _, content := range []string{
+		"cache: all",
+		"log_level: verbose",
+		"profiles:\n  - match: {name: \"[\"}",
+		"profiles:\n  - match: {name: web}\n    cache: all",
+		// unknown options
+		"readonly: true",
+		"daemonize: true",
+		"mount_point: \"{{.Name\"",
+		// only unix sockets are supported
+		"engine: tcp://127.0.0.1:2375",
+		"watch:\n  - mount_point: /mnt/{{.Name}}",
+		"watch:\n  - name: web\n    mount_point: /mnt/web\n    options: {cache: all}",
+	} {
+		if _, err := loadTestConfig(t, content); err == nil {
+			t.Errorf("Invalid config is loaded: %q", content)
+		}
+	}
+}
+
+// Saving watch rules keeps other keys of the config.
+func TestSaveWatchConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manager_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	original, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := []WatchRule{
+		{Name: "web-*", MountPoint: "~/mnt/{{.Name}}", Options: MountOptions{ReadOnly: boolOption(false)}},
+		{Labels: []string{"com.docker.compose.project=shop"}, MountPoint: "/mnt/shop"},
+	}
+	for _, expected := range [][]WatchRule{rules, rules[1:]} {
+		if err := (&WatchConfig{Rules: expected}).Save(path); err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+		config, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("Saved config cannot be loaded: %v", err)
+		}
+		if !reflect.DeepEqual(config.Watch, expected) {
+			t.Errorf("Incorrect rules saved: expected %+v, actual %+v", expected, config.Watch)
+		}
+		config.Watch = nil
+		if !reflect.DeepEqual(config, original) {
+			t.Errorf("Other keys of the config are not kept: expected %+v, actual %+v", original, config)
+		}
+	}
+}
@@ -8,16 +8,20 @@ import (
 )
 
 type Container struct {
-	Id      string
-	Names   []string
-	Image   string
-	Command string
+	Id      string            `json:"id"`
+	Names   []string          `json:"names"`
+	Image   string            `json:"image"`
+	Command string            `json:"command"`
+	State   string            `json:"state"`
+	Status  string            `json:"status"`
+	Running bool              `json:"running"`
+	Labels  map[string]string `json:"labels"`
 
 	//
-	MountPoint string
-	Mounted    bool
-	ShortId    string
-	Name       string
+	MountPoint string `json:"mount_point,omitempty"`
+	Mounted    bool   `json:"mounted"`
+	ShortId    string `json:"-"`
+	Name       string `json:"name"`
 }
 
 func FromContainer(c *dockerfs.Container) Container {
@@ -26,6 +30,10 @@ func FromContainer(c *dockerfs.Container) Container {
 		Names:   c.Names,
 		Image:   c.Image,
 		Command: c.Command,
+		State:   c.State,
+		Status:  c.Status,
+		Running: c.State == "running",
+		Labels:  c.Labels,
 		ShortId: c.Id[:8],
 		Name:    strings.TrimLeft(c.Names[0], "/"),
 	}
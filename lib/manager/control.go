@@ -0,0 +1,257 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/plesk/docker-fs/lib/log"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Every mount process serves HTTP API on its control socket (path is kept in mount registry):
+//
+//	GET  /status    - registry record of the mount with its current state
+//	GET  /stats     - resource usage of the process
+//	POST /flush     - save files opened for writing to container
+//	POST /refresh   - fetch content again and drop caches (e.g. after container is rebuilt)
+//	PUT  /log-level - change logging level, body: {"level": "debug"}
+//	POST /unmount   - save written files and unmount FS
+
+// Operations of the mounted FS, nil ones are not supported (e.g. by read-only image layers).
+type fsControl struct {
+	flush   func() error
+	refresh func() error
+}
+
+// Resource usage of mount process returned by /stats
+type MountStats struct {
+	Pid        int    `json:"pid"`
+	Uptime     string `json:"uptime"`
+	Goroutines int    `json:"goroutines"`
+	// bytes of allocated heap objects
+	HeapAlloc uint64 `json:"heap_alloc"`
+	// bytes obtained from OS
+	Sys      uint64 `json:"sys"`
+	LogLevel string `json:"log_level"`
+}
+
+// Limits control API calls, including the ones saving written files (/flush, /unmount).
+var controlTimeout = time.Minute
+
+type controlServer struct {
+	server *fuse.Server
+	record *Mount
+	ops    fsControl
+}
+
+// Saves written files and unmounts FS, server.Wait() returns afterwards.
+func (c *controlServer) unmount() error {
+	if err := c.flush(); err != nil {
+		log.Printf("[warning] Failed to save written files: %v", err)
+	}
+	if err := c.server.Unmount(); err != nil {
+		return err
+	}
+	log.Printf("[info] Unmount successful.")
+	return nil
+}
+
+func (c *controlServer) flush() error {
+	if c.ops.flush == nil {
+		return nil
+	}
+	return c.ops.flush()
+}
+
+func (c *controlServer) refresh() error {
+	if c.ops.refresh == nil {
+		return fmt.Errorf("Refresh is not supported by %v", c.record.Target)
+	}
+	return c.ops.refresh()
+}
+
+// Returns registry record of the mount with state detected like on reads of registry,
+// the process is serving the call, so it's running.
+func (c *controlServer) status() (*Mount, error) {
+	mountinfo, err := readMountinfo()
+	if err != nil {
+		return nil, err
+	}
+	mounts := reconcile([]Mount{*c.record}, mountinfo, func(*Mount) bool { return true })
+	return &mounts[0], nil
+}
+
+func (c *controlServer) stats() *MountStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return &MountStats{
+		Pid:        os.Getpid(),
+		Uptime:     time.Since(c.record.Started).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  mem.HeapAlloc,
+		Sys:        mem.Sys,
+		LogLevel:   log.Level().String(),
+	}
+}
+
+func (c *controlServer) setLogLevel(r *http.Request) error {
+	var req struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return err
+	}
+	return log.SetLevel(req.Level)
+}
+
+func (c *controlServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", c.handle(http.MethodGet, func(r *http.Request) (interface{}, error) {
+		return c.status()
+	}))
+	mux.HandleFunc("/stats", c.handle(http.MethodGet, func(r *http.Request) (interface{}, error) {
+		return c.stats(), nil
+	}))
+	mux.HandleFunc("/flush", c.handle(http.MethodPost, func(r *http.Request) (interface{}, error) {
+		return nil, c.flush()
+	}))
+	mux.HandleFunc("/refresh", c.handle(http.MethodPost, func(r *http.Request) (interface{}, error) {
+		return nil, c.refresh()
+	}))
+	mux.HandleFunc("/log-level", c.handle(http.MethodPut, func(r *http.Request) (interface{}, error) {
+		return nil, c.setLogLevel(r)
+	}))
+	mux.HandleFunc("/unmount", c.handle(http.MethodPost, func(r *http.Request) (interface{}, error) {
+		return nil, c.unmount()
+	}))
+	return mux
+}
+
+// Wraps API call: checks method and writes result or error as JSON.
+func (c *controlServer) handle(method string, fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("[debug] Control API: %v %v", r.Method, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != method {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Method is not allowed"})
+			return
+		}
+		result, err := fn(r)
+		if err != nil {
+			log.Printf("[error] Control API: %v %v failed: %v", r.Method, r.URL.Path, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if result == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// Starts serving control socket of the mount, returned function stops it.
+func (m *Manager) listenControl(ctl *controlServer) (socket string, stop func(), err error) {
+	if err := os.MkdirAll(m.registry.dir, 0700); err != nil {
+		return "", nil, err
+	}
+	socket = filepath.Join(m.registry.dir, fmt.Sprintf("mount-%d.sock", os.Getpid()))
+	// left by a crashed process with the same pid
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return "", nil, err
+	}
+	server := &http.Server{Handler: ctl.handler()}
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Printf("[error] Control socket failed: %v", err)
+		}
+	}()
+	return socket, func() {
+		// let /unmount call respond
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+		os.Remove(socket)
+	}, nil
+}
+
+// Calls control API of the mount, response is decoded to result if it's not nil.
+func controlCall(mnt *Mount, method, url string, body, result interface{}) error {
+	if mnt.Socket == "" {
+		return fmt.Errorf("Mount %v has no control socket", mnt.MountPoint)
+	}
+	client := &http.Client{
+		Timeout: controlTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				dialer := net.Dialer{}
+				return dialer.DialContext(ctx, "unix", mnt.Socket)
+			},
+		},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, "http://unix"+url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Control socket of %v is not available: %w", mnt.MountPoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			return fmt.Errorf("%v %v failed: %v", method, url, resp.Status)
+		}
+		return errors.New(apiErr.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// MountStats returns resource usage of the mount process.
+func (m *Manager) MountStats(mnt *Mount) (*MountStats, error) {
+	stats := new(MountStats)
+	if err := controlCall(mnt, http.MethodGet, "/stats", nil, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Flush makes the mount process save files opened for writing.
+func (m *Manager) Flush(mnt *Mount) error {
+	return controlCall(mnt, http.MethodPost, "/flush", nil, nil)
+}
+
+// Refresh makes the mount process fetch content again and drop caches.
+func (m *Manager) Refresh(mnt *Mount) error {
+	return controlCall(mnt, http.MethodPost, "/refresh", nil, nil)
+}
+
+// SetLogLevel changes logging level of the mount process.
+func (m *Manager) SetLogLevel(mnt *Mount, level string) error {
+	return controlCall(mnt, http.MethodPut, "/log-level", map[string]string{"level": level}, nil)
+}
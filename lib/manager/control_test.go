@@ -0,0 +1,80 @@
+package manager
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Serves handler on a control socket in dir, returned function stops it.
+func serveControl(t *testing.T, dir string, handler http.Handler) (socket string, stop func()) {
+	socket = filepath.Join(dir, "mount.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Cannot listen control socket: %v", err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	return socket, func() { server.Close() }
+}
+
+func TestControlStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manager_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	record := &Mount{Pid: os.Getpid(), Target: "web"}
+	socket, stop := serveControl(t, dir, (&controlServer{record: record}).handler())
+	defer stop()
+	record.Socket = socket
+
+	// root is always in mount table, the temporary directory is not a mount point
+	for mountPoint, expected := range map[string]MountState{"/": StateMounted, dir: StateUnmounted} {
+		record.MountPoint = mountPoint
+		var status Mount
+		if err := controlCall(record, http.MethodGet, "/status", nil, &status); err != nil {
+			t.Fatalf("GET /status failed: %v", err)
+		}
+		if status.State != expected || status.Target != "web" {
+			t.Errorf("Incorrect status of %v: expected state %q, actual %+v", mountPoint, expected, status)
+		}
+	}
+}
+
+func TestControlCallTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manager_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// mount process which doesn't respond, e.g. stuck in FS operation
+	done := make(chan struct{})
+	defer close(done)
+	socket, stop := serveControl(t, dir, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer stop()
+
+	timeout := controlTimeout
+	controlTimeout = 100 * time.Millisecond
+	defer func() { controlTimeout = timeout }()
+	finished := make(chan error, 1)
+	go func() {
+		finished <- controlCall(&Mount{MountPoint: dir, Socket: socket}, http.MethodPost, "/flush", nil, nil)
+	}()
+	select {
+	case err := <-finished:
+		if err == nil {
+			t.Errorf("Call of not responding mount process succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Call of not responding mount process is not timed out")
+	}
+}
@@ -0,0 +1,101 @@
+package manager
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/plesk/docker-fs/lib/log"
+
+	godaemon "github.com/sevlyar/go-daemon"
+)
+
+// daemon keeps files of daemonized mount process. They are located in the runtime directory
+// of the mount, while the process keeps working directory of the caller, so relative paths
+// (mount point, compose project, configs) mean the same in both processes.
+type daemon struct {
+	ctx     *godaemon.Context
+	dir     string
+	logFile string
+	pidFile string
+}
+
+func newDaemon(dir string) *daemon {
+	d := &daemon{
+		dir:     dir,
+		logFile: filepath.Join(dir, "docker-fs.log"),
+		pidFile: filepath.Join(dir, "docker-fs.pid"),
+	}
+	d.ctx = &godaemon.Context{
+		LogFileName: d.logFile,
+		LogFilePerm: 0600,
+		PidFileName: d.pidFile,
+		PidFilePerm: 0600,
+	}
+	return d
+}
+
+// Returns daemon files of the mount, paths depend only on the mount point,
+// so log of the mount which failed to start can be found as well.
+func (r *Registry) mountDaemon(mountPoint string) *daemon {
+	// mount point is escaped to be a single path component: /home/user/mnt -> %2Fhome%2Fuser%2Fmnt
+	return newDaemon(filepath.Join(r.dir, "mounts", url.PathEscape(mountPoint)))
+}
+
+// Returns daemon files of the watcher, see watch.go.
+func (r *Registry) watchDaemon() *daemon {
+	return newDaemon(filepath.Join(r.dir, "watch"))
+}
+
+// Runs the process in background, parent is true in the original (foreground) process.
+// Output of the daemon is written to log file of the mount.
+func (m *Manager) daemonize(mountPoint string) (parent bool, err error) {
+	parent, err = m.startDaemon(m.registry.mountDaemon(mountPoint))
+	if err == nil && !parent {
+		log.Printf("[info] Mount process %d is started for %v", os.Getpid(), mountPoint)
+	}
+	return parent, err
+}
+
+func (m *Manager) startDaemon(d *daemon) (parent bool, err error) {
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return false, err
+	}
+	child, err := d.ctx.Reborn()
+	if err != nil {
+		return false, fmt.Errorf("Daemonization failed: %w", err)
+	}
+	if child != nil {
+		return true, nil
+	}
+	m.daemon = d
+	return false, nil
+}
+
+// Removes pid file, log is kept to be inspected later.
+func (d *daemon) release() {
+	if err := d.ctx.Release(); err != nil {
+		log.Printf("[warning] Failed to remove pid file: %v", err)
+	}
+}
+
+// LogFile returns log file of the daemonized mount process of the container (ID or name)
+// or the one mounted to the path. Log of a mount point is found even if its mount failed.
+func (m *Manager) LogFile(containerOrPath string) (string, error) {
+	if mnt, err := m.FindMount(containerOrPath); err == nil {
+		if mnt.LogFile == "" {
+			return "", fmt.Errorf("%v is not mounted in background, so there is no log", mnt.MountPoint)
+		}
+		return mnt.LogFile, nil
+	}
+	path, err := filepath.Abs(containerOrPath)
+	if err != nil {
+		return "", err
+	}
+	file := m.registry.mountDaemon(path).logFile
+	if _, err := os.Stat(file); err != nil {
+		return "", ErrorNotMounted{containerOrPath}
+	}
+	return file, nil
+}
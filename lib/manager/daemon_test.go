@@ -0,0 +1,97 @@
+package manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	godaemon "github.com/sevlyar/go-daemon"
+)
+
+// Address of the fake docker engine, inherited by daemonized test process
+const testEngineEnv = "DOCKER_FS_TEST_ENGINE"
+
+func TestMain(m *testing.M) {
+	if godaemon.WasReborn() {
+		// daemonized mount process of a test, it serves the mount instead of running tests
+		if err := mountRelative(os.Getenv(testEngineEnv)); err != nil {
+			fmt.Fprintf(os.Stderr, "Mount failed: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Called by the test and by its daemonized process, the latter serves the mount.
+func mountRelative(engine string) error {
+	return New(&Config{Engine: engine}).MountContainers(nil, "./mnt", MountOptions{Daemonize: true})
+}
+
+// Serves docker API without containers on unix socket.
+func fakeEngine(t *testing.T, dir string) string {
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Cannot listen %v: %v", socket, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "[]")
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return "unix:" + socket
+}
+
+func TestDaemonRelativeMountPoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manager_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// registry and daemon files of the test
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+	defer os.Unsetenv("XDG_RUNTIME_DIR")
+
+	engine := fakeEngine(t, dir)
+	os.Setenv(testEngineEnv, engine)
+	defer os.Unsetenv(testEngineEnv)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	if err := mountRelative(engine); err != nil {
+		t.Fatalf("mountRelative() failed: %v", err)
+	}
+	mountPoint := filepath.Join(dir, "mnt")
+	if err := WaitMounted(mountPoint, 10*time.Second); err != nil {
+		d := New(nil).registry.mountDaemon(mountPoint)
+		if pid, err := godaemon.ReadPidFile(d.pidFile); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+		log, _ := ioutil.ReadFile(d.logFile)
+		t.Fatalf("WaitMounted() failed: %v\nlog of the mount:\n%s", err, log)
+	}
+	if err := New(nil).Unmount(mountPoint); err != nil {
+		t.Errorf("Unmount() failed: %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/plesk/docker-fs/lib/diff"
+	"github.com/plesk/docker-fs/lib/dockerfs"
+	"github.com/plesk/docker-fs/lib/log"
+)
+
+// Maximal width of +/- graph printed by Diff with Stat option
+const statGraphWidth = 50
+
+type DiffOptions struct {
+	// Print only statistics of changed lines
+	Stat bool
+	// Compare only these paths (directories or glob patterns), all changes are compared if empty
+	Paths []string
+	// Show removed files as well
+	Removed bool
+	// Write git binary patches of binary files, so the diff can be applied by `git apply`
+	Binary bool
+}
+
+// DiffFile describes changes of a regular file in container.
+type DiffFile struct {
+	Path string `json:"path"`
+	// Added, Modified or Removed
+	Kind       string `json:"kind"`
+	Binary     bool   `json:"binary"`
+	Insertions int    `json:"insertions"`
+	Deletions  int    `json:"deletions"`
+	OldSize    int    `json:"old_size"`
+	NewSize    int    `json:"new_size"`
+	// unified diff, it's empty for binary files and if only statistics are requested
+	Patch string `json:"patch,omitempty"`
+}
+
+// Diff prints unified diff of files added or modified in container against
+// the original ones from its image. Originals are taken from a throwaway container
+// created from the same image, it's removed afterwards.
+func (m *Manager) Diff(containerId string, opts DiffOptions, w io.Writer) error {
+	var files, inserted, deleted, maxChanged int
+	// statistics are printed once all files are compared, so graphs are scaled the same way
+	var stats []DiffFile
+	err := m.diff(containerId, opts, func(change *dockerfs.FsChange, oldName, newName string, oldData, newData []byte) error {
+		if !opts.Stat {
+			if opts.Binary && (diff.IsBinary(oldData) || diff.IsBinary(newData)) {
+				return diff.GitBinary(w, oldName, newName, oldData, newData)
+			}
+			return diff.Unified(w, oldName, newName, oldData, newData)
+		}
+		if string(oldData) == string(newData) {
+			return nil
+		}
+		files++
+		stat := DiffFile{Path: change.Path, OldSize: len(oldData), NewSize: len(newData)}
+		if diff.IsBinary(oldData) || diff.IsBinary(newData) {
+			stat.Binary = true
+		} else {
+			stat.Insertions, stat.Deletions = diff.Stat(diff.Lines(diff.SplitLines(oldData), diff.SplitLines(newData)))
+			inserted, deleted = inserted+stat.Insertions, deleted+stat.Deletions
+			if changed := stat.Insertions + stat.Deletions; changed > maxChanged {
+				maxChanged = changed
+			}
+		}
+		stats = append(stats, stat)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !opts.Stat {
+		return nil
+	}
+	for _, stat := range stats {
+		if stat.Binary {
+			fmt.Fprintf(w, " %s | Bin %d -> %d bytes\n", stat.Path, stat.OldSize, stat.NewSize)
+			continue
+		}
+		fmt.Fprintf(w, " %s | %d %s\n", stat.Path, stat.Insertions+stat.Deletions, statGraph(stat.Insertions, stat.Deletions, maxChanged))
+	}
+	fmt.Fprintf(w, " %d files changed, %d insertions(+), %d deletions(-)\n", files, inserted, deleted)
+	return nil
+}
+
+// Returns +/- graph of changed lines, it's scaled to statGraphWidth
+// if the file with most changes doesn't fit like in `git diff --stat`.
+func statGraph(inserted, deleted, maxChanged int) string {
+	if maxChanged > statGraphWidth {
+		inserted, deleted = scaleStat(inserted, maxChanged), scaleStat(deleted, maxChanged)
+	}
+	return strings.Repeat("+", inserted) + strings.Repeat("-", deleted)
+}
+
+// Any change is shown with at least one character, so both +/- parts may take an extra one.
+func scaleStat(lines, maxChanged int) int {
+	if lines == 0 {
+		return 0
+	}
+	return 1 + lines*(statGraphWidth-2)/maxChanged
+}
+
+// DiffFiles returns the same changes as Diff prints, but in structured form.
+func (m *Manager) DiffFiles(containerId string, opts DiffOptions) ([]DiffFile, error) {
+	var result []DiffFile
+	err := m.diff(containerId, opts, func(change *dockerfs.FsChange, oldName, newName string, oldData, newData []byte) error {
+		if string(oldData) == string(newData) {
+			return nil
+		}
+		file := DiffFile{
+			Path:    change.Path,
+			Kind:    change.Kind.String(),
+			Binary:  diff.IsBinary(oldData) || diff.IsBinary(newData),
+			OldSize: len(oldData),
+			NewSize: len(newData),
+		}
+		if !file.Binary {
+			file.Insertions, file.Deletions = diff.Stat(diff.Lines(diff.SplitLines(oldData), diff.SplitLines(newData)))
+			if !opts.Stat {
+				patch := &strings.Builder{}
+				if err := diff.Unified(patch, oldName, newName, oldData, newData); err != nil {
+					return err
+				}
+				file.Patch = patch.String()
+			}
+		}
+		result = append(result, file)
+		return nil
+	})
+	return result, err
+}
+
+// Calls fn for every changed regular file matching options with its original and current content.
+func (m *Manager) diff(containerId string, opts DiffOptions, fn func(change *dockerfs.FsChange, oldName, newName string, oldData, newData []byte) error) error {
+	current, err := m.docker(containerId)
+	if err != nil {
+		return err
+	}
+	info, err := current.ContainerInspect(false)
+	if err != nil {
+		return fmt.Errorf("Cannot inspect container %v: %w", containerId, err)
+	}
+	changes, err := current.GetFsChanges()
+	if err != nil {
+		return err
+	}
+
+	originalId, err := current.ContainerCreate(info.Image)
+	if err != nil {
+		return fmt.Errorf("Cannot create container from image %v: %w", info.Image, err)
+	}
+	original, err := m.docker(originalId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := original.ContainerRemove(); err != nil {
+			log.Printf("[warning] Failed to remove container %v: %v", originalId, err)
+		}
+	}()
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	for i := range changes {
+		change := &changes[i]
+		if (change.Kind == dockerfs.FileRemoved && !opts.Removed) || !matchPaths(change.Path, opts.Paths) {
+			continue
+		}
+		oldName, oldData, err := regularFile(original, change.Path, "a")
+		if err != nil {
+			return err
+		}
+		newName, newData, err := regularFile(current, change.Path, "b")
+		if err != nil {
+			return err
+		}
+		if oldName == "/dev/null" && newName == "/dev/null" {
+			// not a regular file
+			continue
+		}
+		if err := fn(change, oldName, newName, oldData, newData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns name and content of a regular file for diff, name is /dev/null if there is no such file.
+func regularFile(docker dockerfs.DockerMng, path, prefix string) (string, []byte, error) {
+	stat, err := docker.GetPathAttrs(path)
+	if errors.As(err, &dockerfs.ErrorNotFound{}) {
+		return "/dev/null", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if !stat.Mode.IsRegular() {
+		return "/dev/null", nil, nil
+	}
+	data, err := readFile(docker, path)
+	if err != nil {
+		return "", nil, err
+	}
+	return prefix + path, data, nil
+}
+
+func readFile(docker dockerfs.DockerMng, path string) ([]byte, error) {
+	reader, err := docker.GetFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// Checks if path is inside one of the directories or matches one of glob patterns.
+func matchPaths(path string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		dir := filepath.Clean("/" + pattern)
+		if path == dir || dir == "/" || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
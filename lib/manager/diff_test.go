@@ -0,0 +1,31 @@
+package manager
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStatGraph(t *testing.T) {
+	for _, test := range []struct {
+		inserted, deleted, maxChanged int
+		expected                      string
+	}{
+		{2, 1, 3, "++-"},
+		{0, 0, 0, ""},
+		// fits without scaling
+		{30, 20, 50, strings.Repeat("+", 30) + strings.Repeat("-", 20)},
+		{10000, 0, 10000, strings.Repeat("+", 49)},
+		{2, 96, 98, "+" + strings.Repeat("-", 48)},
+		{5000, 5000, 10000, strings.Repeat("+", 25) + strings.Repeat("-", 25)},
+		// small changes of files are still shown
+		{1, 1, 10000, "+-"},
+	} {
+		graph := statGraph(test.inserted, test.deleted, test.maxChanged)
+		if graph != test.expected {
+			t.Errorf("Incorrect graph of +%d -%d (max %d): expected %q, actual %q", test.inserted, test.deleted, test.maxChanged, test.expected, graph)
+		}
+		if len(graph) > statGraphWidth {
+			t.Errorf("Graph of +%d -%d is wider than %d", test.inserted, test.deleted, statGraphWidth)
+		}
+	}
+}
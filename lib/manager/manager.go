@@ -1,80 +1,191 @@
 package manager
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/plesk/docker-fs/lib/log"
 
 	"github.com/plesk/docker-fs/lib/dockerfs"
 
 	"github.com/hanwen/go-fuse/v2/fs"
-	"github.com/hanwen/go-fuse/v2/fuse"
-
-	daemon "github.com/sevlyar/go-daemon"
 )
 
 type Manager struct {
-	statusPath string
+	registry   *Registry
+	config     *Config
+	dockerAddr string
+	// returns docker API manager of the container instead of the engine one, set by tests
+	newDocker func(containerId string) (dockerfs.DockerMng, error)
+
+	// set in daemonized mount process
+	daemon *daemon
 }
 
-func New() *Manager {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		log.Printf("[warning] Cannot detect user home directory. Use /tmp.")
-		home = "/tmp"
+// New creates manager working with docker engine of the config, nil config means defaults.
+func New(config *Config) *Manager {
+	if config == nil {
+		config = &Config{}
+	}
+	m := &Manager{
+		registry:   NewRegistry(),
+		config:     config,
+		dockerAddr: dockerfs.DefaultOptions().DockerAddr,
 	}
-	return &Manager{
-		statusPath: filepath.Join(home, ".dockerfs.status.json"),
+	if config.Engine != "" {
+		m.dockerAddr = config.Engine
 	}
+	return m
+}
+
+func (m *Manager) Config() *Config {
+	return m.config
 }
 
 func (m *Manager) ListContainers() ([]Container, error) {
-	httpc, err := dockerfs.NewClient("unix:/var/run/docker.sock")
+	dmng, err := m.docker("")
 	if err != nil {
 		return nil, err
 	}
-	dmng := dockerfs.NewDockerMng(httpc, "")
 	list, err := dmng.ContainersList()
 	if err != nil {
 		return nil, err
 	}
-	status, err := m.readStatus()
+	mounts, err := m.Mounts()
 	if err != nil {
 		return nil, err
 	}
+	mountPoints := make(map[string]string)
+	for _, mnt := range mounts {
+		if mnt.ContainerId != "" && mnt.Mounted() {
+			mountPoints[mnt.ContainerId] = mnt.MountPoint
+		}
+	}
 	var result []Container
 	for _, c := range list {
 		ct := FromContainer(&c)
-		if s, ok := status[ct.Id]; ok {
-			ct.MountPoint = s
-			ct.Mounted = ct.MountPoint != ""
-		}
+		ct.MountPoint = mountPoints[ct.Id]
+		ct.Mounted = ct.MountPoint != ""
 		result = append(result, ct)
 	}
 	return result, nil
 }
 
-func (m *Manager) MountContainer(containerId, mountPoint string, daemonize bool) error {
-	if err := m.writeStatus(containerId, mountPoint); err != nil {
+// Returns options of the container set in config overridden by the given ones.
+func (m *Manager) containerOptions(info *dockerfs.ContainerInfo, opts MountOptions) MountOptions {
+	result := m.config.ContainerOptions(strings.TrimPrefix(info.Name, "/"), info.Config.Image, info.Config.Labels)
+	result.Override(opts)
+	return result
+}
+
+// Returns docker API manager of the container.
+func (m *Manager) docker(containerId string) (dockerfs.DockerMng, error) {
+	if m.newDocker != nil {
+		return m.newDocker(containerId)
+	}
+	httpc, err := dockerfs.NewClient(m.dockerAddr)
+	if err != nil {
+		return nil, err
+	}
+	return dockerfs.NewDockerMng(httpc, containerId), nil
+}
+
+// Returns full ID of the container referred by ID or name.
+func (m *Manager) containerId(idOrName string) (string, error) {
+	docker, err := m.docker(idOrName)
+	if err != nil {
+		return "", err
+	}
+	info, err := docker.ContainerInspect(false)
+	if err != nil {
+		return "", fmt.Errorf("Cannot inspect container %v: %w", idOrName, err)
+	}
+	return info.Id, nil
+}
+
+// MountImage mounts image FS read-only. A stopped container is created from the image
+// to read its content and removed on unmount. With Layers option image layers are read
+// from `docker save` archive instead. Options override the ones set in config for the image.
+func (m *Manager) MountImage(image, mountPoint string, opts MountOptions) error {
+	options := m.config.ImageOptions(image)
+	options.Override(opts)
+	opts = options
+	if err := setLogLevel(opts); err != nil {
 		return err
 	}
 
-	if daemonize {
-		ctx := daemon.Context{}
-		child, err := ctx.Reborn()
-		if err != nil {
-			return fmt.Errorf("Daemonization failed: %w", err)
+	// daemon files are kept by absolute path of the mount point, see mountDaemon
+	mountPoint, err := filepath.Abs(mountPoint)
+	if err != nil {
+		return err
+	}
+	if opts.Daemonize {
+		// daemonize first, so the container is created (and removed) by the daemon only
+		parent, err := m.daemonize(mountPoint)
+		if err != nil || parent {
+			return err
 		}
-		if child != nil {
-			// parent process
-			return nil
+		opts.Daemonize = false
+	}
+	if opts.Layers {
+		return m.mountImageLayers(image, mountPoint, opts)
+	}
+
+	docker, err := m.docker("")
+	if err != nil {
+		return err
+	}
+	log.Printf("[info] Creating container from image %v...", image)
+	containerId, err := docker.ContainerCreate(image)
+	if err != nil {
+		return fmt.Errorf("Cannot create container from image %v: %w", image, err)
+	}
+	if docker, err = m.docker(containerId); err != nil {
+		return err
+	}
+
+	readOnly := true
+	opts.ReadOnly = &readOnly
+	err = m.mountContainer(containerId, mountPoint, opts, Mount{Target: image})
+	if rmErr := docker.ContainerRemove(); rmErr != nil {
+		log.Printf("[warning] Failed to remove container %v: %v", containerId, rmErr)
+	}
+	return err
+}
+
+// MountContainer mounts container FS, options override the ones set in config for the container.
+func (m *Manager) MountContainer(containerId, mountPoint string, opts MountOptions) error {
+	docker, err := m.docker(containerId)
+	if err != nil {
+		return err
+	}
+	info, err := docker.ContainerInspect(false)
+	if err != nil {
+		return fmt.Errorf("Cannot inspect container %v: %w", containerId, err)
+	}
+	opts = m.containerOptions(info, opts)
+	if err := setLogLevel(opts); err != nil {
+		return err
+	}
+	// mounts are recorded by ID, while container can be referred by name
+	return m.mountContainer(info.Id, mountPoint, opts, Mount{ContainerId: info.Id, Target: containerId})
+}
+
+func (m *Manager) mountContainer(containerId, mountPoint string, opts MountOptions, record Mount) error {
+	// daemon files are kept by absolute path of the mount point, see mountDaemon
+	mountPoint, err := filepath.Abs(mountPoint)
+	if err != nil {
+		return err
+	}
+	if opts.Daemonize {
+		parent, err := m.daemonize(mountPoint)
+		if err != nil || parent {
+			return err
 		}
 	}
 
@@ -82,85 +193,163 @@ func (m *Manager) MountContainer(containerId, mountPoint string, daemonize bool)
 	if err := os.MkdirAll(mountPoint, 0755); err != nil {
 		return err
 	}
+	fsOpts, err := m.fsOptions(opts, mountPoint)
+	if err != nil {
+		return err
+	}
 	log.Printf("[info] Fetching content of container %v...", containerId)
-	dockerMng := dockerfs.NewMng(containerId)
+	dockerMng := dockerfs.NewMng(containerId, fsOpts)
 	if err := dockerMng.Init(); err != nil {
 		return fmt.Errorf("dockerMng.Init() failed: %w", err)
 	}
 
-	root := dockerMng.Root()
+	defer dockerMng.Close()
+	record.Options = opts
+	return m.serve(mountPoint, dockerMng.Root(), dockerMng.MountOptions(), record, fsControl{flush: dockerMng.FlushFiles, refresh: dockerMng.Refresh})
+}
 
-	log.Printf("[info] Mounting FS to %v...", mountPoint)
-	server, err := fs.Mount(mountPoint, root, &fs.Options{})
-	if err != nil {
-		return fmt.Errorf("Mount failed: %w", err)
+// MountContainers mounts several containers under one root, every container in its own directory.
+// All running containers are mounted if names are empty. Containers are followed across restarts:
+// they appear on start and disappear on stop. Options override the ones set in config for every container.
+func (m *Manager) MountContainers(names []string, mountPoint string, opts MountOptions) error {
+	target := strings.Join(names, ",")
+	if target == "" {
+		target = "all containers"
 	}
-
-	log.Printf("[info] Setting up signal handler...")
-	osSignalChannel := make(chan os.Signal, 1)
-	signal.Notify(osSignalChannel, syscall.SIGTERM, syscall.SIGINT)
-	go shutdown(server, osSignalChannel)
-
-	log.Printf("[info] OK!")
-	server.Wait()
-	log.Printf("[info] Server finished.")
-
-	return m.writeStatus(containerId, "")
+	return m.mountMulti(dockerfs.SelectContainers(names), mountPoint, opts, Mount{Target: target})
 }
 
-func (m *Manager) UnmountContainer(id, path string) error {
-	cmd := exec.Command("umount", path)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	_ = cmd.Run()
-	return m.writeStatus(id, "")
+// MountComposeProject mounts containers of docker compose project, every service in its own directory.
+func (m *Manager) MountComposeProject(project, mountPoint string, opts MountOptions) error {
+	return m.mountMulti(dockerfs.SelectComposeProject(project), mountPoint, opts, Mount{Target: "compose project " + project})
 }
 
-func (m *Manager) writeStatus(id, path string) error {
-	fmt.Printf("write status: %q = %q\n", id, path)
-	status, err := m.readStatus()
+func (m *Manager) mountMulti(selector dockerfs.ContainerSelector, mountPoint string, opts MountOptions, record Mount) error {
+	// daemon files are kept by absolute path of the mount point, see mountDaemon
+	mountPoint, err := filepath.Abs(mountPoint)
 	if err != nil {
 		return err
 	}
-	if path != "" {
-		absPath, err := filepath.Abs(path)
-		if err != nil {
+	// options of the FS itself (e.g. cache mode) can't differ by container
+	global := m.config.Options()
+	global.Override(opts)
+	if err := setLogLevel(global); err != nil {
+		return err
+	}
+	if opts.Daemonize {
+		parent, err := m.daemonize(mountPoint)
+		if err != nil || parent {
 			return err
 		}
-		status[id] = absPath
-	} else {
-		delete(status, id)
 	}
-	data, err := json.Marshal(status)
+
+	log.Printf("[info] Check if mount directory exists (%v)...", mountPoint)
+	if err := os.MkdirAll(mountPoint, 0755); err != nil {
+		return err
+	}
+	fsOpts, err := m.fsOptions(global, mountPoint)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("status => %s\n", data)
-	return ioutil.WriteFile(m.statusPath, data, 0644)
+	// profiles of config are applied to every container
+	containerOptions := func(docker dockerfs.DockerMng) (dockerfs.Options, error) {
+		info, err := docker.ContainerInspect(false)
+		if err != nil {
+			return dockerfs.Options{}, err
+		}
+		return m.fsOptions(m.containerOptions(info, opts), mountPoint)
+	}
+	multi := dockerfs.NewMultiMng(selector, fsOpts, containerOptions)
+	log.Printf("[info] Fetching content of containers...")
+	if err := multi.Init(); err != nil {
+		return fmt.Errorf("Cannot fetch content of containers: %w", err)
+	}
+	defer multi.Close()
+	record.Options = global
+	return m.serve(mountPoint, multi.Root(), multi.MountOptions(), record, fsControl{flush: multi.FlushFiles, refresh: multi.Refresh})
 }
 
-func (m *Manager) readStatus() (map[string]string, error) {
-	data, err := ioutil.ReadFile(m.statusPath)
-	if os.IsNotExist(err) {
-		return map[string]string{}, nil
+// Mounts image read-only with every layer in its own directory and the merged view.
+func (m *Manager) mountImageLayers(image, mountPoint string, opts MountOptions) error {
+	docker, err := m.docker("")
+	if err != nil {
+		return err
+	}
+	log.Printf("[info] Check if mount directory exists (%v)...", mountPoint)
+	if err := os.MkdirAll(mountPoint, 0755); err != nil {
+		return err
 	}
+	fsOpts, err := m.fsOptions(opts, mountPoint)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	status := map[string]string{}
-	if err := json.Unmarshal(data, &status); err != nil {
-		return nil, err
+	log.Printf("[info] Fetching layers of image %v...", image)
+	layers, err := dockerfs.LoadImageLayers(docker, image)
+	if err != nil {
+		return fmt.Errorf("Cannot load layers of image %v: %w", image, err)
 	}
-	return status, nil
+	defer layers.Close()
+
+	root, mng := layers.Root(fsOpts)
+	// layers are read-only and never change, so there is nothing to flush or refresh
+	return m.serve(mountPoint, root, mng.MountOptions(), Mount{Target: image, Options: opts}, fsControl{})
 }
 
-func shutdown(server *fuse.Server, signals <-chan os.Signal) {
-	<-signals
-	if err := server.Unmount(); err != nil {
-		log.Printf("[warning] server unmount failed: %v", err)
-		os.Exit(1)
+// Mounts FS and serves it until it's unmounted. The mount is recorded in registry
+// and controlled through its control socket meanwhile.
+func (m *Manager) serve(mountPoint string, root fs.InodeEmbedder, options *fs.Options, record Mount, ops fsControl) error {
+	// mount is recorded by the path shown in mountinfo
+	path, err := canonicalPath(mountPoint)
+	if err != nil {
+		return err
+	}
+	log.Printf("[info] Mounting FS to %v...", mountPoint)
+	server, err := fs.Mount(mountPoint, root, options)
+	if err != nil {
+		return fmt.Errorf("Mount failed: %w", err)
 	}
 
-	log.Printf("[info] Unmount successful.")
-	os.Exit(0)
+	record.Pid, record.MountPoint, record.Started = os.Getpid(), path, time.Now()
+	if m.daemon != nil {
+		record.LogFile, record.PidFile = m.daemon.logFile, m.daemon.pidFile
+		defer m.daemon.release()
+	}
+	ctl := &controlServer{server: server, record: &record, ops: ops}
+	socket, stop, err := m.listenControl(ctl)
+	if err != nil {
+		log.Printf("[warning] Failed to create control socket: %v", err)
+	} else {
+		record.Socket = socket
+		defer stop()
+	}
+	if err := m.registry.Add(record); err != nil {
+		log.Printf("[warning] Failed to record mount: %v", err)
+	}
+	defer func() {
+		if err := m.registry.Remove(path); err != nil {
+			log.Printf("[warning] Failed to remove mount record: %v", err)
+		}
+	}()
+
+	log.Printf("[info] Setting up signal handler...")
+	osSignalChannel := make(chan os.Signal, 1)
+	signal.Notify(osSignalChannel, syscall.SIGTERM, syscall.SIGINT)
+	go shutdown(ctl, osSignalChannel)
+
+	log.Printf("[info] OK!")
+	server.Wait()
+	log.Printf("[info] Server finished.")
+	return nil
+}
+
+// Unmounts FS on signal, server.Wait() returns afterwards, so cleanup is done by the mount routine.
+// FS is served further if it can't be unmounted (e.g. it's busy), the next signal retries.
+func shutdown(ctl *controlServer, signals <-chan os.Signal) {
+	for range signals {
+		if err := ctl.unmount(); err != nil {
+			log.Printf("[warning] server unmount failed: %v", err)
+			continue
+		}
+		return
+	}
 }
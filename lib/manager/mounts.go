@@ -0,0 +1,152 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	godaemon "github.com/sevlyar/go-daemon"
+)
+
+type ErrorNotMounted struct {
+	Target string
+}
+
+func (e ErrorNotMounted) Error() string {
+	return fmt.Sprintf("%v is not mounted", e.Target)
+}
+
+// Mounts returns mounts of docker-fs processes sorted by mount point.
+func (m *Manager) Mounts() ([]Mount, error) {
+	return m.registry.List()
+}
+
+// FindMount returns mount of the container (ID or name) or the one mounted to the path.
+func (m *Manager) FindMount(containerOrPath string) (*Mount, error) {
+	mounts, err := m.Mounts()
+	if err != nil {
+		return nil, err
+	}
+	if path, err := canonicalPath(containerOrPath); err == nil {
+		for i := range mounts {
+			if mounts[i].MountPoint == path {
+				return &mounts[i], nil
+			}
+		}
+	}
+	id := containerOrPath
+	if resolved, err := m.containerId(containerOrPath); err == nil {
+		id = resolved
+	}
+	for i := range mounts {
+		if mounts[i].ContainerId == id || mounts[i].Target == containerOrPath {
+			return &mounts[i], nil
+		}
+	}
+	return nil, ErrorNotMounted{containerOrPath}
+}
+
+// GC cleans up mounts of crashed docker-fs processes and returns them.
+func (m *Manager) GC() ([]Mount, error) {
+	return m.registry.GC()
+}
+
+// WaitMounted waits until the path is mounted, e.g. by daemonized mount process.
+func WaitMounted(mountPoint string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		// resolved every time, the mount point may be created meanwhile
+		path, err := canonicalPath(mountPoint)
+		if err != nil {
+			return err
+		}
+		mountinfo, err := readMountinfo()
+		if err != nil {
+			return err
+		}
+		if _, ok := mountinfo[path]; ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%v is not mounted in %v", mountPoint, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Returns absolute path with symlinks resolved, the way it's shown in mountinfo.
+// Mount point of disconnected FS can't be resolved, so only its parent directory is resolved then.
+func canonicalPath(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved, nil
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+		return filepath.Join(dir, filepath.Base(path)), nil
+	}
+	return path, nil
+}
+
+// IsDaemon reports if the process is the daemonized one.
+func IsDaemon() bool {
+	return godaemon.WasReborn()
+}
+
+// Returns mount points of the mount namespace along with their FS types.
+func readMountinfo() (map[string]string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseMountinfo(file)
+}
+
+func parseMountinfo(r io.Reader) (map[string]string, error) {
+	result := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(scanner.Text())
+		// optional fields are terminated with "-", FS type follows it
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || sep+1 >= len(fields) {
+			continue
+		}
+		result[unescapeMountinfo(fields[4])] = fields[sep+1]
+	}
+	return result, scanner.Err()
+}
+
+// Spaces, tabs, newlines and backslashes are octal-escaped in mountinfo: "\040" is a space.
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
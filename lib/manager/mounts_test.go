@@ -0,0 +1,156 @@
+package manager
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manager_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// temporary directory itself may be behind a symlink
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "target")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path     string
+		expected string
+	}{
+		{target, target},
+		{link, target},
+		{link + "/", target},
+		// mount point is not created yet or it's not accessible
+		{filepath.Join(link, "mnt"), filepath.Join(target, "mnt")},
+		{filepath.Join(dir, "missing/mnt"), filepath.Join(dir, "missing/mnt")},
+	} {
+		path, err := canonicalPath(test.path)
+		if err != nil {
+			t.Errorf("canonicalPath(%q) failed: %v", test.path, err)
+			continue
+		}
+		if path != test.expected {
+			t.Errorf("Incorrect canonical path of %q: expected %q, actual %q", test.path, test.expected, path)
+		}
+	}
+}
+
+func TestParseMountinfo(t *testing.T) {
+	for _, test := range []struct {
+		line     string
+		expected map[string]string
+	}{
+		{
+			"36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue",
+			map[string]string{"/mnt2": "ext3"},
+		},
+		// no optional fields
+		{
+			"60 25 0:50 / /home/user/mnt rw,nosuid,nodev,relatime - fuse.dockerfs dockerfs rw,user_id=1000,group_id=1000",
+			map[string]string{"/home/user/mnt": "fuse.dockerfs"},
+		},
+		// several optional fields
+		{
+			"61 25 0:51 / /mnt rw,relatime shared:5 master:2 propagate_from:1 - fuse.dockerfs dockerfs rw",
+			map[string]string{"/mnt": "fuse.dockerfs"},
+		},
+		// octal escapes of space, tab and backslash
+		{
+			`62 25 0:52 / /home/user/my\040mnt\011dir\134x rw - fuse.dockerfs dockerfs rw`,
+			map[string]string{"/home/user/my mnt\tdir\\x": "fuse.dockerfs"},
+		},
+		// malformed lines are skipped
+		{"63 25 0:53 / /mnt rw shared:5", map[string]string{}},
+		{"63 25 0:53 / /mnt rw -", map[string]string{}},
+		{"", map[string]string{}},
+	} {
+		mountinfo, err := parseMountinfo(strings.NewReader(test.line + "\n"))
+		if err != nil {
+			t.Errorf("parseMountinfo(%q) failed: %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(mountinfo, test.expected) {
+			t.Errorf("Incorrect mounts parsed from %q: expected %v, actual %v", test.line, test.expected, mountinfo)
+		}
+	}
+}
+
+func TestUnescapeMountinfo(t *testing.T) {
+	for _, test := range []struct {
+		escaped  string
+		expected string
+	}{
+		{"/mnt", "/mnt"},
+		{`/my\040mnt`, "/my mnt"},
+		{`/a\011b\012c`, "/a\tb\nc"},
+		{`/back\134slash`, `/back\slash`},
+		{`\040\040`, "  "},
+		// invalid and incomplete escapes are kept as is
+		{`/a\09b`, `/a\09b`},
+		{`/a\04`, `/a\04`},
+		{`/a\`, `/a\`},
+	} {
+		if actual := unescapeMountinfo(test.escaped); actual != test.expected {
+			t.Errorf("unescapeMountinfo(%q): expected %q, actual %q", test.escaped, test.expected, actual)
+		}
+	}
+}
+
+func TestFindMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manager_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+	defer os.Unsetenv("XDG_RUNTIME_DIR")
+	mountPoint := filepath.Join(dir, "mnt")
+	if err := os.Mkdir(mountPoint, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(mountPoint, link); err != nil {
+		t.Fatal(err)
+	}
+
+	// containers are not known to the engine, so they are looked up by the recorded ID
+	mng := New(&Config{Engine: fakeEngine(t, dir)})
+	// not mounted, but recorded by the running process
+	if err := mng.registry.Add(Mount{Pid: os.Getpid(), MountPoint: mountPoint, ContainerId: "0123456789ab", Target: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{mountPoint, mountPoint + "/", link, "web", "0123456789ab"} {
+		mnt, err := mng.FindMount(target)
+		if err != nil {
+			t.Errorf("FindMount(%q) failed: %v", target, err)
+			continue
+		}
+		if mnt.MountPoint != mountPoint || mnt.State != StateUnmounted {
+			t.Errorf("Incorrect mount of %q: %+v", target, mnt)
+		}
+	}
+	for _, target := range []string{"db", dir} {
+		if _, err := mng.FindMount(target); !errors.As(err, &ErrorNotMounted{}) {
+			t.Errorf("FindMount(%q): expected ErrorNotMounted, actual %v", target, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"reflect"
+
+	"github.com/plesk/docker-fs/lib/dockerfs"
+	"github.com/plesk/docker-fs/lib/log"
+)
+
+// MountOptions are set in config (globally and by profiles), in watcher rules and by flags,
+// every source overrides options set by the previous one, see Override. Options which are
+// not set are nil, defaults are applied to them by fsOptions.
+type MountOptions struct {
+	// Detach from terminal and run in background, it's decided by the caller
+	Daemonize bool `json:"daemonize" yaml:"-"`
+	// Show image layers separately (only for images), it's decided by the caller
+	Layers bool `json:"layers" yaml:"-"`
+
+	// Make absolute symlinks point to files inside mount point
+	RewriteSymlinks *bool `json:"rewrite_symlinks,omitempty" yaml:"rewrite_symlinks,omitempty"`
+	// Allow writes to host paths bind-mounted into container
+	AllowBindWrites *bool `json:"allow_bind_writes,omitempty" yaml:"allow_bind_writes,omitempty"`
+	// Show only changed files in .dockerfs/diff
+	DiffView *bool `json:"diff_view,omitempty" yaml:"diff_view,omitempty"`
+	// Reject all modifications
+	ReadOnly *bool `json:"read_only,omitempty" yaml:"read_only,omitempty"`
+	// Follow container across restarts and recreation
+	Reconnect *bool `json:"reconnect,omitempty" yaml:"reconnect,omitempty"`
+	// Kernel cache mode: none (default) or metadata
+	Cache *string `json:"cache,omitempty" yaml:"cache,omitempty"`
+	// Owner of files, current user by default
+	Uid *uint32 `json:"uid,omitempty" yaml:"uid,omitempty"`
+	Gid *uint32 `json:"gid,omitempty" yaml:"gid,omitempty"`
+	// Logging level of the mount process
+	LogLevel *string `json:"log_level,omitempty" yaml:"log_level,omitempty"`
+}
+
+// Override sets options which are set in other ones.
+func (o *MountOptions) Override(other MountOptions) {
+	dst, src := reflect.ValueOf(o).Elem(), reflect.ValueOf(other)
+	for i := 0; i < src.NumField(); i++ {
+		field := src.Field(i)
+		if field.Kind() != reflect.Ptr || field.IsNil() {
+			continue
+		}
+		// values are copied, so the options don't share them
+		value := reflect.New(field.Type().Elem())
+		value.Elem().Set(field.Elem())
+		dst.Field(i).Set(value)
+	}
+	o.Daemonize, o.Layers = other.Daemonize, other.Layers
+}
+
+func (o *MountOptions) validate() error {
+	if o.Cache != nil {
+		if _, err := dockerfs.ParseCacheMode(*o.Cache); err != nil {
+			return err
+		}
+	}
+	if o.LogLevel != nil {
+		if _, err := log.ParseLevel(*o.LogLevel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Converts options to the ones of mounted FS.
+func (m *Manager) fsOptions(opts MountOptions, mountPoint string) (dockerfs.Options, error) {
+	fsOpts := dockerfs.DefaultOptions()
+	fsOpts.DockerAddr = m.dockerAddr
+	if opts.Cache != nil {
+		cache, err := dockerfs.ParseCacheMode(*opts.Cache)
+		if err != nil {
+			return fsOpts, err
+		}
+		fsOpts.Cache = cache
+	}
+	if opts.Uid != nil {
+		fsOpts.Uid = *opts.Uid
+	}
+	if opts.Gid != nil {
+		fsOpts.Gid = *opts.Gid
+	}
+	if enabled(opts.RewriteSymlinks) {
+		fsOpts.SymlinkRoot = mountPoint
+	}
+	fsOpts.AllowBindWrites = enabled(opts.AllowBindWrites)
+	fsOpts.DiffView = enabled(opts.DiffView)
+	fsOpts.ReadOnly = enabled(opts.ReadOnly)
+	fsOpts.Reconnect = enabled(opts.Reconnect)
+	return fsOpts, nil
+}
+
+// Sets logging level of the mount process if it's set in options.
+func setLogLevel(opts MountOptions) error {
+	if opts.LogLevel == nil {
+		return nil
+	}
+	return log.SetLevel(*opts.LogLevel)
+}
+
+func enabled(option *bool) bool {
+	return option != nil && *option
+}
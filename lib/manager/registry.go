@@ -0,0 +1,274 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/plesk/docker-fs/lib/log"
+)
+
+// FS type of docker-fs mounts in /proc/self/mountinfo, see dockerfs.Mng.MountOptions
+const fuseType = "fuse.dockerfs"
+
+type MountState string
+
+const (
+	// FS is mounted and served
+	StateMounted MountState = "mounted"
+	// FS is mounted, but its process is gone, so the mount point is not accessible (ENOTCONN)
+	StateDisconnected MountState = "disconnected"
+	// process is running, but FS is not mounted (yet or already)
+	StateUnmounted MountState = "unmounted"
+)
+
+// Mount is a record of mount registry.
+type Mount struct {
+	// process serving the mount
+	Pid        int    `json:"pid"`
+	MountPoint string `json:"mount_point"`
+	// empty if mounted FS is not a container one (e.g. an image)
+	ContainerId string `json:"container_id,omitempty"`
+	// what is mounted: container, image, compose project...
+	Target  string       `json:"target"`
+	Options MountOptions `json:"options"`
+	Started time.Time    `json:"started"`
+	// control socket of the mount process, see control.go
+	Socket string `json:"socket,omitempty"`
+	// log and pid files of daemonized mount process
+	LogFile string `json:"log_file,omitempty"`
+	PidFile string `json:"pid_file,omitempty"`
+
+	// detected on every read of registry
+	State MountState `json:"state"`
+}
+
+func (m *Mount) Mounted() bool {
+	return m.State == StateMounted
+}
+
+// Registry keeps mounts served by docker-fs processes of the user.
+// Records are reconciled against mount table and running processes on every read,
+// so mounts of crashed processes don't show up as mounted.
+type Registry struct {
+	dir string
+}
+
+func NewRegistry() *Registry {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("docker-fs-%d", os.Getuid()))
+	} else {
+		dir = filepath.Join(dir, "docker-fs")
+	}
+	return &Registry{dir: dir}
+}
+
+// Add records mount served by the current process.
+func (r *Registry) Add(mnt Mount) error {
+	return r.update(func(mounts []Mount) []Mount {
+		return append(removeMount(mounts, mnt.MountPoint), mnt)
+	})
+}
+
+// Remove forgets mount to the path.
+func (r *Registry) Remove(mountPoint string) error {
+	return r.update(func(mounts []Mount) []Mount {
+		return removeMount(mounts, mountPoint)
+	})
+}
+
+// List returns mounts sorted by mount point. Records of finished processes,
+// which mount points are not mounted, are dropped.
+func (r *Registry) List() (result []Mount, err error) {
+	err = r.update(func(mounts []Mount) []Mount {
+		result = mounts
+		return mounts
+	})
+	return result, err
+}
+
+// GC lazily unmounts FS of crashed processes (including the ones missing in registry)
+// and drops their records. Returns the cleaned up mounts.
+func (r *Registry) GC() (removed []Mount, err error) {
+	mountinfo, err := readMountinfo()
+	if err != nil {
+		return nil, err
+	}
+	err = r.update(func(mounts []Mount) []Mount {
+		var alive []Mount
+		recorded := make(map[string]bool)
+		for _, mnt := range mounts {
+			recorded[mnt.MountPoint] = true
+			// like for unrecorded mounts, endpoint which still answers is never detached
+			if mnt.State != StateDisconnected || !disconnected(mnt.MountPoint) {
+				alive = append(alive, mnt)
+				continue
+			}
+			if err := lazyUnmount(mnt.MountPoint); err != nil {
+				log.Printf("[warning] Failed to unmount %v: %v", mnt.MountPoint, err)
+				alive = append(alive, mnt)
+				continue
+			}
+			removed = append(removed, mnt)
+		}
+		for path, fsType := range mountinfo {
+			if fsType != fuseType || recorded[path] || !disconnected(path) {
+				continue
+			}
+			if err := lazyUnmount(path); err != nil {
+				log.Printf("[warning] Failed to unmount %v: %v", path, err)
+				continue
+			}
+			removed = append(removed, Mount{MountPoint: path, State: StateDisconnected})
+		}
+		return alive
+	})
+	return removed, err
+}
+
+// Reads records under lock, reconciles them and writes the ones returned by fn.
+func (r *Registry) update(fn func(mounts []Mount) []Mount) error {
+	lock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	mounts, err := r.read()
+	if err != nil {
+		return err
+	}
+	mountinfo, err := readMountinfo()
+	if err != nil {
+		return err
+	}
+	return r.write(fn(reconcile(mounts, mountinfo, mountRunning)))
+}
+
+// Takes exclusive lock of registry, it's released when the returned file is closed.
+func (r *Registry) lock() (*os.File, error) {
+	if err := os.MkdirAll(r.dir, 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(r.dir, "mounts.lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Cannot lock mount registry: %w", err)
+	}
+	return file, nil
+}
+
+func (r *Registry) read() ([]Mount, error) {
+	data, err := ioutil.ReadFile(filepath.Join(r.dir, "mounts.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var mounts []Mount
+	if err := json.Unmarshal(data, &mounts); err != nil {
+		return nil, fmt.Errorf("Cannot parse mount registry: %w", err)
+	}
+	return mounts, nil
+}
+
+// Writes records atomically, so readers never see a partially written file.
+func (r *Registry) write(mounts []Mount) error {
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].MountPoint < mounts[j].MountPoint })
+	data, err := json.MarshalIndent(mounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(r.dir, "mounts.json")
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Detects state of mounts by mount table (see readMountinfo) and running processes
+// and drops the ones which are gone completely.
+func reconcile(mounts []Mount, mountinfo map[string]string, isRunning func(mnt *Mount) bool) []Mount {
+	var result []Mount
+	for _, mnt := range mounts {
+		_, mounted := mountinfo[mnt.MountPoint]
+		running := isRunning(&mnt)
+		switch {
+		case mounted && running:
+			mnt.State = StateMounted
+		case mounted:
+			mnt.State = StateDisconnected
+		case running:
+			mnt.State = StateUnmounted
+		default:
+			log.Printf("[debug] Mount of %v to %v is gone (pid %d)", mnt.Target, mnt.MountPoint, mnt.Pid)
+			continue
+		}
+		result = append(result, mnt)
+	}
+	return result
+}
+
+func removeMount(mounts []Mount, mountPoint string) []Mount {
+	var result []Mount
+	for _, mnt := range mounts {
+		if mnt.MountPoint != mountPoint {
+			result = append(result, mnt)
+		}
+	}
+	return result
+}
+
+// Checks that process with the pid is running. Processes of other users can't be signaled,
+// they can't serve mounts of the user anyway.
+func processRunning(pid int) bool {
+	return pid > 0 && syscall.Kill(pid, 0) == nil
+}
+
+// Checks that process of the mount is running. Pid of a process which is gone may be reused
+// by another one, so the process has to accept connections to control socket of the mount.
+func mountRunning(mnt *Mount) bool {
+	if !processRunning(mnt.Pid) {
+		return false
+	}
+	if mnt.Socket == "" {
+		// control socket failed to start, there is nothing else to check
+		return true
+	}
+	conn, err := net.DialTimeout("unix", mnt.Socket, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Checks that pid file is locked by the running daemon (see godaemon.CreatePidFile),
+// the lock is released once the process is gone, even if its pid is reused.
+func pidFileLocked(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	// the lock taken here is released on close
+	defer file.Close()
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == syscall.EWOULDBLOCK
+}
+
+// Checks if FUSE endpoint of the mount point is not connected to any process.
+func disconnected(path string) bool {
+	_, err := os.Stat(path)
+	return errors.Is(err, syscall.ENOTCONN)
+}
@@ -0,0 +1,123 @@
+package manager
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	godaemon "github.com/sevlyar/go-daemon"
+)
+
+func TestReconcile(t *testing.T) {
+	mountinfo := map[string]string{
+		"/mnt/mounted":      fuseType,
+		"/mnt/disconnected": fuseType,
+	}
+	running := map[int]bool{1: true, 3: true}
+	mounts := []Mount{
+		{Pid: 1, MountPoint: "/mnt/mounted"},
+		{Pid: 2, MountPoint: "/mnt/disconnected"},
+		{Pid: 3, MountPoint: "/mnt/unmounted"},
+		{Pid: 4, MountPoint: "/mnt/gone"},
+	}
+	expected := map[string]MountState{
+		"/mnt/mounted":      StateMounted,
+		"/mnt/disconnected": StateDisconnected,
+		"/mnt/unmounted":    StateUnmounted,
+	}
+
+	result := reconcile(mounts, mountinfo, func(mnt *Mount) bool { return running[mnt.Pid] })
+	actual := make(map[string]MountState)
+	for _, mnt := range result {
+		actual[mnt.MountPoint] = mnt.State
+	}
+	if len(actual) != len(expected) {
+		t.Errorf("Incorrect mounts reconciled: expected %v, actual %v", expected, actual)
+	}
+	for mountPoint, state := range expected {
+		if actual[mountPoint] != state {
+			t.Errorf("Incorrect state of %v: expected %q, actual %q", mountPoint, state, actual[mountPoint])
+		}
+	}
+}
+
+func TestProcessRunning(t *testing.T) {
+	if !processRunning(os.Getpid()) {
+		t.Errorf("Current process is not detected as running")
+	}
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Cannot run process: %v", err)
+	}
+	if processRunning(cmd.Process.Pid) {
+		t.Errorf("Finished process %d is detected as running", cmd.Process.Pid)
+	}
+	if processRunning(0) {
+		t.Errorf("Pid 0 is detected as running")
+	}
+}
+
+func TestMountRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manager_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket, stop := serveControl(t, dir, http.NotFoundHandler())
+	defer stop()
+
+	// another process running as if it reused pid of a mount process
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Cannot start process: %v", err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	for _, test := range []struct {
+		mnt      Mount
+		expected bool
+	}{
+		{Mount{Pid: os.Getpid(), Socket: socket}, true},
+		// control socket failed to start
+		{Mount{Pid: os.Getpid()}, true},
+		{Mount{Pid: cmd.Process.Pid, Socket: filepath.Join(dir, "gone.sock")}, false},
+		{Mount{Pid: 0, Socket: socket}, false},
+	} {
+		if running := mountRunning(&test.mnt); running != test.expected {
+			t.Errorf("Incorrect running state of %+v: expected %v, actual %v", test.mnt, test.expected, running)
+		}
+	}
+}
+
+func TestPidFileLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manager_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "docker-fs.pid")
+	if pidFileLocked(path) {
+		t.Errorf("Missing pid file is detected as locked")
+	}
+	lock, err := godaemon.CreatePidFile(path, 0600)
+	if err != nil {
+		t.Fatalf("CreatePidFile() failed: %v", err)
+	}
+	if !pidFileLocked(path) {
+		t.Errorf("Pid file of running daemon is not detected as locked")
+	}
+	// lock is not taken by the check
+	if !pidFileLocked(path) {
+		t.Errorf("Pid file is unlocked by the check")
+	}
+	// left by a crashed process
+	lock.Unlock()
+	lock.Close()
+	if pidFileLocked(path) {
+		t.Errorf("Pid file of finished daemon is detected as locked")
+	}
+}
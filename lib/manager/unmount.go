@@ -0,0 +1,110 @@
+package manager
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/plesk/docker-fs/lib/log"
+)
+
+// How long the serving process is waited for to unmount FS on signal
+const unmountTimeout = 10 * time.Second
+
+// Unmount unmounts FS mounted to the path. The serving process is asked to do it
+// (through control socket or with SIGTERM), so written files are saved first. fusermount is used if there is no such process or
+// it failed to unmount FS in time, lazy unmount is the last resort for busy mount points.
+func (m *Manager) Unmount(mountPoint string) error {
+	path, err := canonicalPath(mountPoint)
+	if err != nil {
+		return err
+	}
+	mounts, err := m.Mounts()
+	if err != nil {
+		return err
+	}
+	recorded := false
+	for _, mnt := range mounts {
+		if mnt.MountPoint != path || !mountRunning(&mnt) {
+			continue
+		}
+		recorded = true
+		if mnt.Socket != "" {
+			log.Printf("[info] Asking process %d to unmount %v through control socket...", mnt.Pid, path)
+			err := controlCall(&mnt, http.MethodPost, "/unmount", nil, nil)
+			if err == nil {
+				return waitUnmounted(path, unmountTimeout)
+			}
+			log.Printf("[warning] Process %d failed to unmount %v: %v", mnt.Pid, path, err)
+			// it's busy, so signal would fail as well
+			break
+		}
+		log.Printf("[info] Asking process %d to unmount %v...", mnt.Pid, path)
+		if err := syscall.Kill(mnt.Pid, syscall.SIGTERM); err != nil {
+			log.Printf("[warning] Cannot signal process %d: %v", mnt.Pid, err)
+			break
+		}
+		if err := waitUnmounted(path, unmountTimeout); err != nil {
+			log.Printf("[warning] Process %d didn't unmount %v: %v", mnt.Pid, path, err)
+			break
+		}
+		return nil
+	}
+
+	mountinfo, err := readMountinfo()
+	if err != nil {
+		return err
+	}
+	if _, ok := mountinfo[path]; !ok {
+		if recorded {
+			// the process has unmounted it after all
+			return nil
+		}
+		return ErrorNotMounted{mountPoint}
+	}
+	if err := fusermount(path, false); err == nil {
+		return nil
+	} else if err := lazyUnmount(path); err != nil {
+		return err
+	}
+	log.Printf("[warning] %v is busy, it's detached and will be unmounted once it's not used", path)
+	return nil
+}
+
+// Waits until the path is not mounted.
+func waitUnmounted(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		mountinfo, err := readMountinfo()
+		if err != nil {
+			return err
+		}
+		if _, ok := mountinfo[path]; !ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("still mounted after %v", timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Detaches mount point even if it's busy, it's unmounted completely once it's not used.
+func lazyUnmount(path string) error {
+	return fusermount(path, true)
+}
+
+func fusermount(path string, lazy bool) error {
+	args := []string{"-u", path}
+	if lazy {
+		args = []string{"-u", "-z", path}
+	}
+	out, err := exec.Command("fusermount", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("fusermount failed: %w (%s)", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
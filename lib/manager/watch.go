@@ -0,0 +1,430 @@
+package manager
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/plesk/docker-fs/lib/dockerfs"
+	"github.com/plesk/docker-fs/lib/log"
+
+	godaemon "github.com/sevlyar/go-daemon"
+	"gopkg.in/yaml.v2"
+)
+
+// How long `watch stop` waits for the watcher to unmount containers
+const watcherStopTimeout = 60 * time.Second
+
+type ErrorWatcherRunning struct {
+	Pid int
+}
+
+func (e ErrorWatcherRunning) Error() string {
+	return fmt.Sprintf("Watcher is already running (pid %d)", e.Pid)
+}
+
+type ErrorWatcherNotRunning struct{}
+
+func (e ErrorWatcherNotRunning) Error() string {
+	return "Watcher is not running"
+}
+
+// WatchRule selects containers which are mounted by watcher once they start.
+// Container has to match both name pattern and labels if they are set.
+type WatchRule struct {
+	// glob pattern of container name, e.g. "web-*"
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// labels container must have: "key" or "key=value"
+	Labels []string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// template of mount point with fields of Container, e.g. "~/mnt/{{.Name}}"
+	MountPoint string       `json:"mount_point" yaml:"mount_point"`
+	Options    MountOptions `json:"options" yaml:"options,omitempty"`
+}
+
+func (r *WatchRule) String() string {
+	var selectors []string
+	if r.Name != "" {
+		selectors = append(selectors, "name "+r.Name)
+	}
+	for _, label := range r.Labels {
+		selectors = append(selectors, "label "+label)
+	}
+	return fmt.Sprintf("%s -> %s", strings.Join(selectors, ", "), r.MountPoint)
+}
+
+// Validate checks that the rule selects containers and its mount point can be expanded.
+func (r *WatchRule) Validate() error {
+	if r.Name == "" && len(r.Labels) == 0 {
+		return fmt.Errorf("Either name pattern or labels must be set")
+	}
+	if _, err := path.Match(r.Name, ""); err != nil {
+		return fmt.Errorf("Invalid name pattern %q: %w", r.Name, err)
+	}
+	if !strings.HasPrefix(r.MountPoint, "/") && !strings.HasPrefix(r.MountPoint, "~/") {
+		// relative paths would depend on the directory the watcher happens to be started in
+		return fmt.Errorf("Mount point must be absolute or start with ~/: %q", r.MountPoint)
+	}
+	if _, err := template.New("mount point").Parse(r.MountPoint); err != nil {
+		return fmt.Errorf("Invalid mount point template: %w", err)
+	}
+	return nil
+}
+
+func (r *WatchRule) Matches(ct *Container) bool {
+	if r.Name != "" {
+		if matched, _ := path.Match(r.Name, ct.Name); !matched {
+			return false
+		}
+	}
+	return matchLabels(r.Labels, ct.Labels)
+}
+
+// Returns mount point of the container: expanded template with ~ replaced by home directory.
+func (r *WatchRule) mountPointOf(ct *Container) (string, error) {
+	tmpl, err := template.New("mount point").Option("missingkey=error").Parse(r.MountPoint)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ct); err != nil {
+		return "", err
+	}
+	result, err := expandHome(buf.String())
+	if err != nil {
+		return "", err
+	}
+	return filepath.Clean(result), nil
+}
+
+// WatchConfig keeps watcher rules, the first matching rule is applied to container.
+// Rules are kept under the watch key of the config, see Config.
+type WatchConfig struct {
+	Rules []WatchRule
+}
+
+// LoadWatchConfig reads watcher rules from the config, no rules are returned if the file doesn't exist.
+func LoadWatchConfig(path string) (*WatchConfig, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return &WatchConfig{Rules: config.Watch}, nil
+}
+
+// Save replaces rules in the config keeping its other keys (comments are not kept) and writes
+// it atomically, so running watcher never reads a partially written file.
+func (c *WatchConfig) Save(path string) error {
+	var doc yaml.MapSlice
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("Cannot parse config %v: %w", path, err)
+	}
+	rules := c.Rules
+	if rules == nil {
+		rules = []WatchRule{}
+	}
+	replaced := false
+	for i := range doc {
+		if doc[i].Key == "watch" {
+			doc[i].Value, replaced = rules, true
+		}
+	}
+	if !replaced {
+		doc = append(doc, yaml.MapItem{Key: "watch", Value: rules})
+	}
+	if data, err = yaml.Marshal(doc); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Returns the first rule matching the container.
+func (c *WatchConfig) match(ct *Container) *WatchRule {
+	for i := range c.Rules {
+		if c.Rules[i].Matches(ct) {
+			return &c.Rules[i]
+		}
+	}
+	return nil
+}
+
+// MountFunc mounts container in background and returns once it's mounted.
+type MountFunc func(ct *Container, mountPoint string, opts MountOptions) error
+
+// Watcher mounts containers matching rules when they start and unmounts them when they stop.
+// Rules are read on every container start, so changes of the config apply without restart.
+type Watcher struct {
+	mng        *Manager
+	configPath string
+	mount      MountFunc
+
+	mutex sync.Mutex
+	// mount points of containers mounted by the watcher
+	mounts map[string]string
+	// containers being mounted, false if the container is stopped meanwhile
+	pending map[string]bool
+	// mounts in progress, they are waited for on stop
+	mounting sync.WaitGroup
+	stopped  bool
+}
+
+func (m *Manager) NewWatcher(configPath string, mount MountFunc) *Watcher {
+	return &Watcher{
+		mng:        m,
+		configPath: configPath,
+		mount:      mount,
+		mounts:     make(map[string]string),
+		pending:    make(map[string]bool),
+	}
+}
+
+// Run mounts running containers matching rules and follows their starts and stops
+// until the process gets SIGINT or SIGTERM, mounted containers are unmounted then.
+// With daemonize it returns right away in the original process, while the watcher runs in background.
+func (w *Watcher) Run(daemonize bool) error {
+	d := w.mng.registry.watchDaemon()
+	if daemonize {
+		if !godaemon.WasReborn() {
+			if pid, err := WatcherPid(); err == nil {
+				return ErrorWatcherRunning{pid}
+			}
+		}
+		parent, err := w.mng.startDaemon(d)
+		if err != nil || parent {
+			return err
+		}
+		defer d.release()
+		// mount processes started by the watcher must daemonize themselves
+		os.Unsetenv(godaemon.MARK_NAME)
+	} else {
+		if err := os.MkdirAll(d.dir, 0700); err != nil {
+			return err
+		}
+		// pid file is locked, so another watcher can't be started
+		pidFile, err := godaemon.CreatePidFile(d.pidFile, 0600)
+		if err != nil {
+			if pid, err := WatcherPid(); err == nil {
+				return ErrorWatcherRunning{pid}
+			}
+			return err
+		}
+		defer pidFile.Remove()
+	}
+	log.Printf("[info] Watcher %d is started, rules are read from %v", os.Getpid(), w.configPath)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	// subscribe to events first, so containers started while they are listed are not missed
+	docker, err := w.mng.docker("")
+	if err != nil {
+		return err
+	}
+	events, err := dockerfs.SubscribeEvents(docker)
+	if err != nil {
+		return fmt.Errorf("Cannot follow docker events: %w", err)
+	}
+	cts, err := w.mng.ListContainers()
+	if err != nil {
+		return err
+	}
+	for i := range cts {
+		if cts[i].Running {
+			w.mountContainer(&cts[i])
+		}
+	}
+	go events.Follow(w.handleEvent)
+
+	sig := <-signals
+	log.Printf("[info] Got %v, unmounting containers...", sig)
+	w.stop()
+	return nil
+}
+
+func (w *Watcher) handleEvent(event *dockerfs.ContainerEvent) {
+	switch event.Action {
+	case "start":
+		ct, err := w.container(event.Actor.ID)
+		if err != nil {
+			log.Printf("[error] Cannot get container %v: %v", event.Actor.ID, err)
+			return
+		}
+		w.mountContainer(ct)
+	case "die", "destroy":
+		w.unmountContainer(event.Actor.ID)
+	}
+}
+
+func (w *Watcher) container(id string) (*Container, error) {
+	cts, err := w.mng.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+	for i := range cts {
+		if cts[i].Id == id {
+			return &cts[i], nil
+		}
+	}
+	return nil, fmt.Errorf("container is not found")
+}
+
+// Mounts container in background if it matches a rule and isn't mounted yet.
+// Every container is mounted by its own goroutine, so a slow mount doesn't hold up others.
+func (w *Watcher) mountContainer(ct *Container) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, ok := w.pending[ct.Id]; ok {
+		// restarted while it's being mounted
+		w.pending[ct.Id] = true
+		return
+	}
+	if w.stopped || ct.Mounted || w.mounts[ct.Id] != "" {
+		return
+	}
+	w.pending[ct.Id] = true
+	w.mounting.Add(1)
+	go func() {
+		defer w.mounting.Done()
+		mountPoint, ok := w.mountByRule(ct)
+
+		w.mutex.Lock()
+		wanted := w.pending[ct.Id] && !w.stopped
+		delete(w.pending, ct.Id)
+		if ok && wanted {
+			w.mounts[ct.Id] = mountPoint
+		}
+		w.mutex.Unlock()
+
+		if ok && !wanted {
+			log.Printf("[info] Container %v is stopped while it was mounted, unmounting %v...", ct.Name, mountPoint)
+			if err := w.mng.Unmount(mountPoint); err != nil {
+				log.Printf("[error] Failed to unmount %v: %v", mountPoint, err)
+			}
+		}
+	}()
+}
+
+// Mounts container by the first matching rule, returns false if it's not mounted.
+func (w *Watcher) mountByRule(ct *Container) (string, bool) {
+	config, err := LoadWatchConfig(w.configPath)
+	if err != nil {
+		log.Printf("[error] %v", err)
+		return "", false
+	}
+	rule := config.match(ct)
+	if rule == nil {
+		log.Printf("[debug] Container %v doesn't match any rule", ct.Name)
+		return "", false
+	}
+	mountPoint, err := rule.mountPointOf(ct)
+	if err != nil {
+		log.Printf("[error] Cannot get mount point of container %v by rule %v: %v", ct.Name, rule, err)
+		return "", false
+	}
+	log.Printf("[info] Mounting container %v to %v (rule %v)...", ct.Name, mountPoint, rule)
+	if err := w.mount(ct, mountPoint, rule.Options); err != nil {
+		log.Printf("[error] Failed to mount container %v to %v: %v", ct.Name, mountPoint, err)
+		return "", false
+	}
+	return mountPoint, true
+}
+
+// Unmounts container if it was mounted by the watcher.
+func (w *Watcher) unmountContainer(id string) {
+	w.mutex.Lock()
+	if _, ok := w.pending[id]; ok {
+		// it's unmounted once the mount is finished
+		w.pending[id] = false
+		w.mutex.Unlock()
+		return
+	}
+	mountPoint := w.mounts[id]
+	// the mount isn't retried, anyway it's unmounted with the watcher
+	delete(w.mounts, id)
+	w.mutex.Unlock()
+	if mountPoint == "" {
+		return
+	}
+	log.Printf("[info] Container %v is stopped, unmounting %v...", id, mountPoint)
+	if err := w.mng.Unmount(mountPoint); err != nil {
+		log.Printf("[error] Failed to unmount %v: %v", mountPoint, err)
+	}
+}
+
+// Unmounts all containers mounted by the watcher, events are ignored afterwards.
+func (w *Watcher) stop() {
+	w.mutex.Lock()
+	w.stopped = true
+	mounts := w.mounts
+	w.mounts = make(map[string]string)
+	w.mutex.Unlock()
+
+	for _, mountPoint := range mounts {
+		if err := w.mng.Unmount(mountPoint); err != nil {
+			log.Printf("[error] Failed to unmount %v: %v", mountPoint, err)
+		}
+	}
+	// containers being mounted are unmounted right after their mounts finish
+	w.mounting.Wait()
+}
+
+// WatcherPid returns pid of the running watcher.
+func WatcherPid() (int, error) {
+	pidFile := NewRegistry().watchDaemon().pidFile
+	pid, err := godaemon.ReadPidFile(pidFile)
+	if err != nil || !processRunning(pid) || !pidFileLocked(pidFile) {
+		return 0, ErrorWatcherNotRunning{}
+	}
+	return pid, nil
+}
+
+// StopWatcher stops the running watcher and waits until it unmounts containers.
+func StopWatcher() error {
+	pid, err := WatcherPid()
+	if err != nil {
+		return err
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	pidFile := NewRegistry().watchDaemon().pidFile
+	deadline := time.Now().Add(watcherStopTimeout)
+	for processRunning(pid) && pidFileLocked(pidFile) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Watcher (pid %d) is still running after %v", pid, watcherStopTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+// WatcherLogFile returns log of the watcher running in background.
+func WatcherLogFile() (string, error) {
+	file := NewRegistry().watchDaemon().logFile
+	if _, err := os.Stat(file); err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrorWatcherNotRunning{}
+		}
+		return "", err
+	}
+	return file, nil
+}
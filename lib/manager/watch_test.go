@@ -0,0 +1,141 @@
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchRuleMatches(t *testing.T) {
+	web := &Container{Name: "web-1", Labels: map[string]string{"com.docker.compose.project": "shop", "tier": ""}}
+	for _, test := range []struct {
+		rule     WatchRule
+		expected bool
+	}{
+		{WatchRule{Name: "web-*"}, true},
+		{WatchRule{Name: "web-1"}, true},
+		{WatchRule{Name: "db-*"}, false},
+		{WatchRule{Labels: []string{"tier"}}, true},
+		{WatchRule{Labels: []string{"com.docker.compose.project=shop"}}, true},
+		{WatchRule{Labels: []string{"com.docker.compose.project=blog"}}, false},
+		{WatchRule{Labels: []string{"com.docker.compose.project", "tier="}}, true},
+		{WatchRule{Labels: []string{"missing"}}, false},
+		// both name and labels must match
+		{WatchRule{Name: "web-*", Labels: []string{"tier"}}, true},
+		{WatchRule{Name: "web-*", Labels: []string{"missing"}}, false},
+		{WatchRule{Name: "db-*", Labels: []string{"tier"}}, false},
+	} {
+		if actual := test.rule.Matches(web); actual != test.expected {
+			t.Errorf("Rule %v matches %v: expected %v, actual %v", &test.rule, web.Name, test.expected, actual)
+		}
+	}
+}
+
+func TestWatchRuleMountPoint(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := &Container{Id: "0123456789ab", Name: "web-1", Labels: map[string]string{"com.docker.compose.service": "web"}}
+	for _, test := range []struct {
+		template string
+		expected string
+	}{
+		{"/mnt/{{.Name}}", "/mnt/web-1"},
+		{"~/mnt/{{.Id}}", filepath.Join(home, "mnt/0123456789ab")},
+		{`/mnt/{{index .Labels "com.docker.compose.service"}}/`, "/mnt/web"},
+		{"/mnt/../{{.Name}}", "/web-1"},
+		// missing field
+		{"/mnt/{{.Missing}}", ""},
+	} {
+		rule := &WatchRule{Name: "*", MountPoint: test.template}
+		mountPoint, err := rule.mountPointOf(ct)
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("Mount point of %q is expanded: %q", test.template, mountPoint)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Mount point of %q failed: %v", test.template, err)
+			continue
+		}
+		if mountPoint != test.expected {
+			t.Errorf("Incorrect mount point of %q: expected %q, actual %q", test.template, test.expected, mountPoint)
+		}
+	}
+}
+
+func TestWatchRuleValidate(t *testing.T) {
+	for _, test := range []struct {
+		rule  WatchRule
+		valid bool
+	}{
+		{WatchRule{Name: "web-*", MountPoint: "/mnt/{{.Name}}"}, true},
+		{WatchRule{Labels: []string{"tier"}, MountPoint: "~/mnt/{{.Name}}"}, true},
+		// no selectors
+		{WatchRule{MountPoint: "/mnt/{{.Name}}"}, false},
+		{WatchRule{Name: "[", MountPoint: "/mnt/{{.Name}}"}, false},
+		{WatchRule{Name: "web-*", MountPoint: "mnt/{{.Name}}"}, false},
+		{WatchRule{Name: "web-*", MountPoint: "./mnt"}, false},
+		{WatchRule{Name: "web-*", MountPoint: "/mnt/{{.Name"}, false},
+	} {
+		err := test.rule.Validate()
+		if test.valid && err != nil {
+			t.Errorf("Rule %v is invalid: %v", &test.rule, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Rule %v is valid", &test.rule)
+		}
+	}
+}
+
+// Slow mount of a container must not hold up mounts of others.
+func TestWatcherMountsConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manager_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+	defer os.Unsetenv("XDG_RUNTIME_DIR")
+	configPath := filepath.Join(dir, "config.yaml")
+	config := &WatchConfig{Rules: []WatchRule{{Name: "*", MountPoint: filepath.Join(dir, "{{.Name}}")}}}
+	if err := config.Save(configPath); err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	mounted := make(chan string, 2)
+	w := New(nil).NewWatcher(configPath, func(ct *Container, mountPoint string, opts MountOptions) error {
+		if ct.Name == "slow" {
+			<-release
+		}
+		mounted <- ct.Name
+		return nil
+	})
+	w.mountContainer(&Container{Id: "0001", Name: "slow"})
+	w.mountContainer(&Container{Id: "0002", Name: "fast"})
+	select {
+	case name := <-mounted:
+		if name != "fast" {
+			t.Errorf("Incorrect container mounted: expected fast, actual %v", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Container is not mounted while another one is being mounted")
+	}
+
+	// stopped while it's mounted, so it's unmounted right after the mount
+	w.unmountContainer("0001")
+	close(release)
+	w.mounting.Wait()
+	if len(w.mounts) != 1 || w.mounts["0002"] != filepath.Join(dir, "fast") || len(w.pending) != 0 {
+		t.Errorf("Incorrect containers mounted: %v, pending %v", w.mounts, w.pending)
+	}
+	w.stop()
+	if len(w.mounts) != 0 {
+		t.Errorf("Containers are left mounted: %v", w.mounts)
+	}
+}
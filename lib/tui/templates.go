@@ -6,14 +6,15 @@ import (
 
 var listTemplates = &promptui.SelectTemplates{
 	Label:    "Select container to mount/unmount. {{ \"(use ^C to exit)\" | faint }}",
-	Active:   "\U0000261E {{ if .Mounted }}{{ .ShortId | blue | bold }} {{ .Name | blue | bold }} (mounted){{ else }}{{ .ShortId | bold }} {{ .Name | bold }}{{ end }}",
-	Inactive: "  {{ if .Mounted }}{{ .ShortId | blue }} {{ .Name | blue }} (mounted){{else}}{{ .ShortId }} {{ .Name }}{{ end }}",
+	Active:   "\U0000261E {{ if .Mounted }}{{ .ShortId | blue | bold }} {{ .Name | blue | bold }} (mounted){{ else }}{{ .ShortId | bold }} {{ .Name | bold }}{{ end }}{{ if not .Running }} {{ .State | faint }}{{ end }}",
+	Inactive: "  {{ if .Mounted }}{{ .ShortId | blue }} {{ .Name | blue }} (mounted){{else}}{{ .ShortId }} {{ .Name }}{{ end }}{{ if not .Running }} {{ .State | faint }}{{ end }}",
 	Details: `
 ------ Container ------
 Id: {{ .Id }}
 Name:  {{ .Names }}
 Image: {{ .Image }}
 Command: {{ .Command }}
+Status: {{ .Status }}
 {{ if .Mounted }}MountPoint: {{ .MountPoint }}{{ end }}`,
 }
 
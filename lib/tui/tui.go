@@ -78,14 +78,18 @@ func (t *Tui) list() error {
 			return nil
 		}
 		// unmounting
-		if err := t.mng.UnmountContainer(ct.Id, ct.MountPoint); err != nil {
-			return err
+		if err := t.mng.Unmount(ct.MountPoint); err != nil {
+			return fmt.Errorf("Cannot unmount %v: %w", ct.MountPoint, err)
 		}
 	} else {
 		// Mounting
+		defaultPath, err := t.mng.Config().DefaultMountPoint(&cts[i])
+		if err != nil {
+			return fmt.Errorf("Cannot get mount point of %v: %w", cts[i].Name, err)
+		}
 		promptPath := promptui.Prompt{
 			Label:     "Choose path to mount docker container",
-			Default:   fmt.Sprintf("./mount-%v", cts[i].Name),
+			Default:   defaultPath,
 			AllowEdit: true,
 		}
 
@@ -99,7 +103,13 @@ func (t *Tui) list() error {
 			return fmt.Errorf("Cannot detect executable path: %w", err)
 		}
 
-		cmd := exec.Command(executable, "-id", cts[i].Id, "-mount", mountPoint, "-daemonize")
+		// returns once FS is mounted, so it's listed as mounted right away
+		// options are taken from config by the mount command itself
+		args := []string{"mount", cts[i].Id, mountPoint}
+		if engine := t.mng.Config().Engine; engine != "" {
+			args = []string{"mount", "-engine", engine, cts[i].Id, mountPoint}
+		}
+		cmd := exec.Command(executable, args...)
 		if err := cmd.Run(); err != nil {
 			return fmt.Errorf("Mount command failed: %w", err)
 		}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/plesk/docker-fs/lib/log"
 	"github.com/plesk/docker-fs/lib/tui"
@@ -17,14 +18,35 @@ var (
 	// Docker container ID (or name)
 	containerId string
 
+	// Comma separated names of containers to mount under one root
+	containerNames string
+
+	// Mount all running containers under one root
+	allContainers bool
+
+	// Docker compose project to mount, every service to its own directory
+	composeProject string
+
+	// Detect compose project from compose file in the current directory
+	composeDetect bool
+
+	// Docker image to mount read-only instead of container
+	imageRef string
+
+	// Show image layers separately
+	imageLayers bool
+
 	// Directory to mount container FS
 	mountPoint string
 
-	//
+	// Docker socket path, engine of config is used unless it's set
 	dockerSocketAddr string
 
 	daemonize bool
 
+	// Mount options given by flags, they override config
+	mountOpts manager.MountOptions
+
 	logLevel       string
 	verbose, quiet bool
 )
@@ -33,14 +55,31 @@ func init() {
 	flag.StringVar(&containerId, "id", "", "Docker containter ID (or name)")
 	flag.StringVar(&containerId, "i", "", "Docker containter ID (or name)")
 
+	flag.StringVar(&containerNames, "containers", "", "Comma separated names of containers to mount, every one to its own directory")
+	flag.BoolVar(&allContainers, "all", false, "Mount all running containers, every one to its own directory")
+
+	flag.StringVar(&composeProject, "compose-project", "", "Docker compose project to mount, every service to its own directory")
+	flag.BoolVar(&composeDetect, "compose", false, "Mount compose project defined in the current directory")
+
+	flag.StringVar(&imageRef, "image", "", "Docker image to mount read-only")
+
+	flag.BoolVar(&imageLayers, "layers", false, "Show every image layer in its own directory (with -image)")
+
 	flag.StringVar(&mountPoint, "mount", "", "Mount point for containter FS")
 	flag.StringVar(&mountPoint, "m", "", "Mount point for containter FS")
 
 	flag.BoolVar(&daemonize, "daemonize", false, "Daemonize fuse process")
 	flag.BoolVar(&daemonize, "d", false, "Daemonize fuse process")
 
-	// TODO make http support
-	flag.StringVar(&dockerSocketAddr, "docker-socket", "/var/run/docker.sock", "Docker socket")
+	flag.Var(boolFlag{&mountOpts.RewriteSymlinks}, "rewrite-symlinks", "Make absolute symlinks point to files inside mount point")
+
+	flag.Var(boolFlag{&mountOpts.AllowBindWrites}, "allow-bind-writes", "Allow writes to host paths bind-mounted into container")
+
+	flag.Var(boolFlag{&mountOpts.DiffView}, "diff-view", "Show only changed files in .dockerfs/diff")
+
+	flag.Var(boolFlag{&mountOpts.Reconnect}, "reconnect", "Follow container across restarts and recreation (by name or compose service)")
+
+	flag.StringVar(&dockerSocketAddr, "docker-socket", "/var/run/docker.sock", "Docker socket (overrides engine of config)")
 
 	flag.StringVar(&logLevel, "log-level", "warning", "Logging level")
 	flag.BoolVar(&verbose, "verbose", false, "Increase loggin level to 'debug'")
@@ -50,16 +89,84 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ls":
+			os.Exit(lsCommand(os.Args[2:]))
+		case "mount":
+			os.Exit(mountCommand(os.Args[2:]))
+		case "umount":
+			os.Exit(umountCommand(os.Args[2:]))
+		case "status":
+			os.Exit(statusCommand(os.Args[2:]))
+		case "gc":
+			os.Exit(gcCommand(os.Args[2:]))
+		case "stats":
+			os.Exit(statsCommand(os.Args[2:]))
+		case "flush", "refresh":
+			os.Exit(mountCallCommand(os.Args[1], os.Args[2:]))
+		case "log-level":
+			os.Exit(logLevelCommand(os.Args[2:]))
+		case "logs":
+			os.Exit(logsCommand(os.Args[2:]))
+		case "watch":
+			os.Exit(watchCommand(os.Args[2:]))
+		case "diff":
+			os.Exit(diffCommand(os.Args[2:]))
+		case "export-changes":
+			os.Exit(exportChangesCommand(os.Args[2:]))
+		case "import-changes":
+			os.Exit(importChangesCommand(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 
-	if containerId != "" {
+	config := loadConfig()
+	if isFlagSet(flag.CommandLine, "docker-socket") {
+		config.Engine = "unix:" + dockerSocketAddr
+	}
+
+	multi := containerNames != "" || allContainers
+	compose := composeProject != "" || composeDetect
+	if containerId != "" || imageRef != "" || multi || compose {
+		if countTrue(containerId != "", imageRef != "", multi, compose) > 1 {
+			fmt.Fprintf(os.Stderr, "Either container, several containers, compose project or image can be mounted.\n")
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
 		if mountPoint == "" {
 			fmt.Fprintf(os.Stderr, "Mount point is not specified.\n")
 			flag.Usage()
-			os.Exit(2)
+			os.Exit(exitUsage)
 		}
-		mng := manager.New()
-		if err := mng.MountContainer(containerId, mountPoint, daemonize); err != nil {
+		mng := manager.New(config)
+		// options set in config are overridden by flags in the manager
+		opts := mountOpts
+		opts.Layers, opts.Daemonize = imageLayers, daemonize
+		if isFlagSet(flag.CommandLine, "log-level") {
+			opts.LogLevel = &logLevel
+		}
+		var err error
+		if imageRef != "" {
+			err = mng.MountImage(imageRef, mountPoint, opts)
+		} else if compose {
+			if composeProject == "" {
+				if composeProject, err = manager.DetectComposeProject("."); err != nil {
+					log.Fatal(err)
+				}
+			}
+			err = mng.MountComposeProject(composeProject, mountPoint, opts)
+		} else if multi {
+			var names []string
+			if !allContainers {
+				names = strings.Split(containerNames, ",")
+			}
+			err = mng.MountContainers(names, mountPoint, opts)
+		} else {
+			err = mng.MountContainer(containerId, mountPoint, opts)
+		}
+		if err != nil {
 			log.Fatal(err)
 		}
 		return
@@ -68,7 +175,10 @@ func main() {
 	if verbose && quiet {
 		fmt.Fprintf(os.Stderr, "Cannot make it quite and verbose simultaneously\n")
 		flag.Usage()
-		os.Exit(2)
+		os.Exit(exitUsage)
+	}
+	if config.LogLevel != nil && !isFlagSet(flag.CommandLine, "log-level") {
+		logLevel = *config.LogLevel
 	}
 	if verbose {
 		logLevel = log.Debug.String()
@@ -80,14 +190,23 @@ func main() {
 		log.Printf("[warning] cannot set log level: %q (%v)", logLevel, err)
 	}
 
-	mng := manager.New()
-	ui := tui.NewTui(mng)
+	ui := tui.NewTui(manager.New(config))
 
 	if err := ui.Run(tui.List); err != nil {
 		log.Fatal(err)
 	}
 }
 
+func countTrue(values ...bool) int {
+	n := 0
+	for _, v := range values {
+		if v {
+			n++
+		}
+	}
+	return n
+}
+
 func shutdown(server *fuse.Server, signals <-chan os.Signal) {
 	<-signals
 	if err := server.Unmount(); err != nil {
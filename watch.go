@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/plesk/docker-fs/lib/manager"
+)
+
+// Repeatable string flag, e.g. -label a=1 -label b
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// docker-fs watch [-foreground] [-config path]
+// docker-fs watch add|rm|ls|stop|logs ...
+func watchCommand(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "add":
+			return watchAddCommand(args[1:])
+		case "rm":
+			return watchRmCommand(args[1:])
+		case "ls":
+			return watchLsCommand(args[1:])
+		case "stop":
+			return watchStopCommand(args[1:])
+		case "logs":
+			return watchLogsCommand(args[1:])
+		}
+	}
+
+	flags := newFlagSet("watch", "[options]",
+		"Mount containers matching rules once they start and unmount them once they stop.\n"+
+			"Rules are managed by `watch add`, `watch rm` and `watch ls`, the watcher is stopped by `watch stop`.")
+	foreground := flags.Bool("foreground", false, "Don't daemonize, watch until SIGINT or SIGTERM")
+	configPath := addWatchConfigFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	watcher := newManager().NewWatcher(*configPath, mountProcess)
+	if err := watcher.Run(!*foreground); err != nil {
+		return printError(err)
+	}
+	return exitOK
+}
+
+func addWatchConfigFlag(flags *flag.FlagSet) *string {
+	path, err := manager.ConfigPath()
+	if err != nil {
+		path = ""
+	}
+	return flags.String("config", path, "Path of the config, rules are kept under its watch key")
+}
+
+// Mounts container by `docker-fs mount`, so every mount is served (and logged) by its own process.
+func mountProcess(ct *manager.Container, mountPoint string, opts manager.MountOptions) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("Cannot detect executable path: %w", err)
+	}
+	args := append([]string{"mount"}, mountArgs(opts)...)
+	args = append(args, ct.Id, mountPoint)
+	// returns once FS is mounted
+	output, err := exec.Command(executable, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Mount command failed: %w: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// docker-fs watch add [options] <mount-point-template>
+func watchAddCommand(args []string) int {
+	flags := newFlagSet("watch add", "[options] <mount-point-template>",
+		"Add rule of the watcher. Mount point template may use fields of the container, e.g. ~/mnt/{{.Name}}\n"+
+			"or /mnt/{{index .Labels \"com.docker.compose.service\"}}.")
+	var rule manager.WatchRule
+	flags.StringVar(&rule.Name, "name", "", "Glob pattern of container name, e.g. web-*")
+	flags.Var((*stringsFlag)(&rule.Labels), "label", "Label container must have: key or key=value, may be repeated")
+	addMountFlags(flags, &rule.Options)
+	configPath := addWatchConfigFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	rule.MountPoint = flags.Arg(0)
+	if err := rule.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitUsage
+	}
+
+	config, err := manager.LoadWatchConfig(*configPath)
+	if err != nil {
+		return printError(err)
+	}
+	config.Rules = append(config.Rules, rule)
+	if err := config.Save(*configPath); err != nil {
+		return printError(err)
+	}
+	return exitOK
+}
+
+// docker-fs watch rm <rule-number>
+func watchRmCommand(args []string) int {
+	flags := newFlagSet("watch rm", "<rule-number>", "Remove rule of the watcher, rules are numbered by `watch ls`.")
+	configPath := addWatchConfigFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	config, err := manager.LoadWatchConfig(*configPath)
+	if err != nil {
+		return printError(err)
+	}
+	n, err := strconv.Atoi(flags.Arg(0))
+	if err != nil || n < 1 || n > len(config.Rules) {
+		fmt.Fprintf(os.Stderr, "There is no rule %v\n", flags.Arg(0))
+		return exitNotFound
+	}
+	config.Rules = append(config.Rules[:n-1], config.Rules[n:]...)
+	if err := config.Save(*configPath); err != nil {
+		return printError(err)
+	}
+	return exitOK
+}
+
+// docker-fs watch ls [-json]
+func watchLsCommand(args []string) int {
+	flags := newFlagSet("watch ls", "[options]", "List rules of the watcher and its state.")
+	jsonOutput := flags.Bool("json", false, "Print JSON")
+	configPath := addWatchConfigFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	config, err := manager.LoadWatchConfig(*configPath)
+	if err != nil {
+		return printError(err)
+	}
+	pid, _ := manager.WatcherPid()
+	if *jsonOutput {
+		if config.Rules == nil {
+			config.Rules = []manager.WatchRule{}
+		}
+		return printJSON(map[string]interface{}{"pid": pid, "rules": config.Rules})
+	}
+	if pid != 0 {
+		fmt.Printf("Watcher is running (pid %d)\n\n", pid)
+	} else {
+		fmt.Printf("Watcher is not running\n\n")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "#\tNAME\tLABELS\tMOUNT POINT\tOPTIONS\n")
+	for i, rule := range config.Rules {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i+1, rule.Name, strings.Join(rule.Labels, ","),
+			rule.MountPoint, strings.Join(mountArgs(rule.Options), " "))
+	}
+	w.Flush()
+	return exitOK
+}
+
+// docker-fs watch stop
+func watchStopCommand(args []string) int {
+	flags := newFlagSet("watch stop", "", "Stop the watcher, containers mounted by it are unmounted.")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	if err := manager.StopWatcher(); err != nil {
+		return printError(err)
+	}
+	return exitOK
+}
+
+// docker-fs watch logs [-f] [-n lines]
+func watchLogsCommand(args []string) int {
+	flags := newFlagSet("watch logs", "[options]", "Print log of the watcher running in background.")
+	follow := flags.Bool("f", false, "Follow log output")
+	lines := flags.Int("n", 10, "Number of last lines to print, 0 prints the whole log")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	path, err := manager.WatcherLogFile()
+	if err != nil {
+		return printError(err)
+	}
+	return printLog(path, *lines, *follow)
+}